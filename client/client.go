@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"github.com/ohsu-comp-bio/funnel/util"
 	"golang.org/x/net/context"
@@ -144,6 +145,48 @@ func (c *Client) CancelTask(ctx context.Context, req *tes.CancelTaskRequest) (*t
 	return resp, nil
 }
 
+// PauseTask POSTs to /v1/tasks/{id}:pause
+func (c *Client) PauseTask(ctx context.Context, req *control.PauseTaskRequest) (*control.PauseTaskResponse, error) {
+	u := c.address + "/v1/tasks/" + req.Id + ":pause"
+	hreq, _ := http.NewRequest("POST", u, nil)
+	hreq.WithContext(ctx)
+	hreq.Header.Add("Content-Type", "application/json")
+	hreq.SetBasicAuth("funnel", c.Password)
+	body, err := util.CheckHTTPResponse(c.client.Do(hreq))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response
+	resp := &control.PauseTaskResponse{}
+	err = jsonpb.UnmarshalString(string(body), resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ResumeTask POSTs to /v1/tasks/{id}:resume
+func (c *Client) ResumeTask(ctx context.Context, req *control.ResumeTaskRequest) (*control.ResumeTaskResponse, error) {
+	u := c.address + "/v1/tasks/" + req.Id + ":resume"
+	hreq, _ := http.NewRequest("POST", u, nil)
+	hreq.WithContext(ctx)
+	hreq.Header.Add("Content-Type", "application/json")
+	hreq.SetBasicAuth("funnel", c.Password)
+	body, err := util.CheckHTTPResponse(c.client.Do(hreq))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response
+	resp := &control.ResumeTaskResponse{}
+	err = jsonpb.UnmarshalString(string(body), resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
 // GetServiceInfo returns result of GET /v1/tasks/service-info
 func (c *Client) GetServiceInfo(ctx context.Context, req *tes.ServiceInfoRequest) (*tes.ServiceInfo, error) {
 	u := c.address + "/v1/tasks/service-info"
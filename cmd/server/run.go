@@ -43,6 +43,7 @@ type Server struct {
 	DB       server.Database
 	SDB      scheduler.Database
 	SBackend scheduler.Backend
+	Monitor  *server.HeartbeatMonitor
 }
 
 // NewServer returns a new Funnel server + scheduler based on the given config.
@@ -131,11 +132,30 @@ func NewServer(conf config.Config, log *logger.Logger) (*Server, error) {
 	srv := server.DefaultServer(db, conf.Server)
 	srv.Log = log
 
-	return &Server{srv, sched, db, sdb, sbackend}, nil
+	if conf.Server.PreflightInputs {
+		p, err := server.NewInputPreflight(conf.Server, conf.Worker.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("error occurred while setting up input preflight: %v", err)
+		}
+		srv.InputPreflight = p
+	}
+
+	var monitor *server.HeartbeatMonitor
+	if conf.Server.HeartbeatTimeout > 0 {
+		monitor = &server.HeartbeatMonitor{
+			Log:   log.Sub("heartbeat"),
+			DB:    db,
+			Conf:  conf.Server,
+			Retry: conf.Worker.Retry,
+		}
+	}
+
+	return &Server{srv, sched, db, sdb, sbackend, monitor}, nil
 }
 
 // Run runs a default Funnel server.
-// This opens a database, and starts an API server, scheduler and task logger.
+// This opens a database, and starts an API server, scheduler, task heartbeat
+// monitor and task logger.
 // This blocks indefinitely.
 func (s *Server) Run(ctx context.Context) error {
 
@@ -152,6 +172,14 @@ func (s *Server) Run(ctx context.Context) error {
 		}()
 	}
 
+	// Start the monitor of task heartbeats, which fails or retries
+	// tasks whose worker was lost.
+	if s.Monitor != nil {
+		go func() {
+			errch <- s.Monitor.Run(ctx)
+		}()
+	}
+
 	// Block until done.
 	// Server and scheduler must be stopped via the context.
 	return <-errch
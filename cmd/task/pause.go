@@ -0,0 +1,59 @@
+package task
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/client"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
+	"golang.org/x/net/context"
+	"io"
+)
+
+// Pause runs the "task pause" CLI command, which connects to the server,
+// calls PauseTask() on each ID, and writes output to the given writer.
+func Pause(server string, ids []string, writer io.Writer) error {
+	cli := client.NewClient(server)
+	res := []string{}
+
+	for _, taskID := range ids {
+		resp, err := cli.PauseTask(context.Background(), &control.PauseTaskRequest{Id: taskID})
+		if err != nil {
+			return err
+		}
+		// PauseTaskResponse is an empty struct
+		out, err := cli.Marshaler.MarshalToString(resp)
+		if err != nil {
+			return err
+		}
+		res = append(res, out)
+	}
+
+	for _, x := range res {
+		fmt.Fprintln(writer, x)
+	}
+	return nil
+}
+
+// Resume runs the "task resume" CLI command, which connects to the server,
+// calls ResumeTask() on each ID, and writes output to the given writer.
+func Resume(server string, ids []string, writer io.Writer) error {
+	cli := client.NewClient(server)
+	res := []string{}
+
+	for _, taskID := range ids {
+		resp, err := cli.ResumeTask(context.Background(), &control.ResumeTaskRequest{Id: taskID})
+		if err != nil {
+			return err
+		}
+		// ResumeTaskResponse is an empty struct
+		out, err := cli.Marshaler.MarshalToString(resp)
+		if err != nil {
+			return err
+		}
+		res = append(res, out)
+	}
+
+	for _, x := range res {
+		fmt.Fprintln(writer, x)
+	}
+	return nil
+}
@@ -20,6 +20,8 @@ func newCommandHooks() (*cobra.Command, *hooks) {
 		Get:    Get,
 		List:   List,
 		Cancel: Cancel,
+		Pause:  Pause,
+		Resume: Resume,
 		Wait:   Wait,
 	}
 
@@ -95,6 +97,24 @@ func newCommandHooks() (*cobra.Command, *hooks) {
 		},
 	}
 
+	pause := &cobra.Command{
+		Use:   "pause [taskID ...]",
+		Short: "Pause one or more running tasks by ID.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return h.Pause(tesServer, args, cmd.OutOrStdout())
+		},
+	}
+
+	resume := &cobra.Command{
+		Use:   "resume [taskID ...]",
+		Short: "Resume one or more paused tasks by ID.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return h.Resume(tesServer, args, cmd.OutOrStdout())
+		},
+	}
+
 	wait := &cobra.Command{
 		Use:   "wait [taskID...]",
 		Short: "Wait for one or more tasks to complete.\n",
@@ -104,7 +124,7 @@ func newCommandHooks() (*cobra.Command, *hooks) {
 		},
 	}
 
-	cmd.AddCommand(create, get, list, cancel, wait)
+	cmd.AddCommand(create, get, list, cancel, pause, resume, wait)
 	return cmd, h
 }
 
@@ -113,6 +133,8 @@ type hooks struct {
 	Get    func(server string, ids []string, view string, w io.Writer) error
 	List   func(server, view, pageToken string, pageSize uint32, all bool, w io.Writer) error
 	Cancel func(server string, ids []string, w io.Writer) error
+	Pause  func(server string, ids []string, w io.Writer) error
+	Resume func(server string, ids []string, w io.Writer) error
 	Wait   func(server string, ids []string) error
 }
 
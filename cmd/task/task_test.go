@@ -80,6 +80,18 @@ func TestServerDefault(t *testing.T) {
 		}
 		return nil
 	}
+	h.Pause = func(server string, ids []string, w io.Writer) error {
+		if server != "http://localhost:8000" {
+			t.Errorf("expected localhost default, got '%s'", server)
+		}
+		return nil
+	}
+	h.Resume = func(server string, ids []string, w io.Writer) error {
+		if server != "http://localhost:8000" {
+			t.Errorf("expected localhost default, got '%s'", server)
+		}
+		return nil
+	}
 	h.Wait = func(server string, ids []string) error {
 		if server != "http://localhost:8000" {
 			t.Errorf("expected localhost default, got '%s'", server)
@@ -99,6 +111,12 @@ func TestServerDefault(t *testing.T) {
 	cmd.SetArgs([]string{"cancel", "1"})
 	cmd.Execute()
 
+	cmd.SetArgs([]string{"pause", "1"})
+	cmd.Execute()
+
+	cmd.SetArgs([]string{"resume", "1"})
+	cmd.Execute()
+
 	cmd.SetArgs([]string{"wait", "1"})
 	cmd.Execute()
 }
@@ -134,6 +152,18 @@ func TestServerEnv(t *testing.T) {
 		}
 		return nil
 	}
+	h.Pause = func(server string, ids []string, w io.Writer) error {
+		if server != "foobar" {
+			t.Error("expected foobar")
+		}
+		return nil
+	}
+	h.Resume = func(server string, ids []string, w io.Writer) error {
+		if server != "foobar" {
+			t.Error("expected foobar")
+		}
+		return nil
+	}
 	h.Wait = func(server string, ids []string) error {
 		if server != "foobar" {
 			t.Error("expected foobar")
@@ -153,6 +183,12 @@ func TestServerEnv(t *testing.T) {
 	cmd.SetArgs([]string{"cancel", "1"})
 	cmd.Execute()
 
+	cmd.SetArgs([]string{"pause", "1"})
+	cmd.Execute()
+
+	cmd.SetArgs([]string{"resume", "1"})
+	cmd.Execute()
+
 	cmd.SetArgs([]string{"wait", "1"})
 	cmd.Execute()
 }
@@ -87,6 +87,11 @@ func NewDefaultWorker(conf config.Worker, taskID string, log *logger.Logger) (wo
 		writers = append(writers, writer)
 	}
 
+	cache, err := worker.OpenInputCache(conf)
+	if err != nil {
+		return nil, err
+	}
+
 	m := events.MultiWriter(writers...)
 	ew := &events.ErrLogger{Writer: m, Log: log}
 
@@ -96,5 +101,6 @@ func NewDefaultWorker(conf config.Worker, taskID string, log *logger.Logger) (wo
 		Store:      storage.Storage{},
 		TaskReader: reader,
 		Event:      events.NewTaskWriter(taskID, 0, conf.Logger.Level, ew),
+		InputCache: cache,
 	}, nil
 }
@@ -49,7 +49,11 @@ func (b *HPCBackend) Submit(task *tes.Task) error {
 func (b *HPCBackend) setupTemplatedHPCSubmit(task *tes.Task) (string, error) {
 	var err error
 
-	// TODO document that these working dirs need manual cleanup
+	// The worker deletes this directory when the task is done, if
+	// config.Worker.WorkDirCleanup.Delete allows it. The janitor which
+	// applies KeepFor and MaxBytes only runs on nodes, so with the default
+	// policy ("never") HPC working directories are kept until they're
+	// deleted manually.
 	workdir := path.Join(b.conf.Worker.WorkDir, task.Id)
 	workdir, _ = filepath.Abs(workdir)
 	err = util.EnsureDir(workdir)
@@ -6,6 +6,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/logger"
 	pbs "github.com/ohsu-comp-bio/funnel/proto/scheduler"
 	"github.com/ohsu-comp-bio/funnel/util"
+	"github.com/ohsu-comp-bio/funnel/worker"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"time"
@@ -37,6 +38,22 @@ func NewNode(conf config.Config, log *logger.Logger, factory WorkerFactory) (*No
 
 	workerConf := conf.Worker
 	workerConf.WorkDir = conf.Scheduler.Node.WorkDir
+	workerConf.NodeID = conf.Scheduler.Node.ID
+
+	// Open the input cache, which is shared by all the node's workers.
+	_, err = worker.OpenInputCache(workerConf)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := newRunSet()
+
+	// The janitor cleans up the working directories of finished tasks.
+	janitor := &worker.Janitor{
+		Conf:    workerConf,
+		Log:     log.Sub("janitor"),
+		Running: workers.Contains,
+	}
 
 	return &Node{
 		conf:       conf.Scheduler.Node,
@@ -45,7 +62,8 @@ func NewNode(conf config.Config, log *logger.Logger, factory WorkerFactory) (*No
 		log:        log,
 		resources:  res,
 		newWorker:  factory,
-		workers:    newRunSet(),
+		workers:    workers,
+		janitor:    janitor,
 		timeout:    timeout,
 		state:      state,
 	}, nil
@@ -66,6 +84,7 @@ type Node struct {
 	resources  pbs.Resources
 	newWorker  WorkerFactory
 	workers    *runSet
+	janitor    *worker.Janitor
 	timeout    util.IdleTimeout
 	state      pbs.NodeState
 }
@@ -80,6 +99,9 @@ func (n *Node) Run(ctx context.Context) {
 	n.state = pbs.NodeState_ALIVE
 	n.checkConnection(ctx)
 	n.sync(ctx)
+	if n.janitor != nil {
+		go n.janitor.Run(ctx)
+	}
 
 	ticker := time.NewTicker(n.conf.UpdateRate)
 	defer ticker.Stop()
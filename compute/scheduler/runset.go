@@ -69,3 +69,11 @@ func (r *runSet) Count() int {
 	defer r.mtx.Unlock()
 	return len(r.runners)
 }
+
+// Contains returns true if the given ID is in the set.
+func (r *runSet) Contains(id string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	_, ok := r.runners[id]
+	return ok
+}
@@ -96,6 +96,12 @@ func (s *Scheduler) Schedule(ctx context.Context) error {
 
 	for _, task := range s.DB.ReadQueue(s.Conf.ScheduleChunk) {
 		offer := s.Backend.GetOffer(task)
+		if offer != nil && assigned(offer.Node, task.Id) {
+			// A retried task may still be assigned to the node which ran
+			// the previous attempt, until that node syncs. Try again later.
+			s.Log.Debug("Task is still assigned to node", "taskID", task.Id, "nodeID", offer.Node.Id)
+			continue
+		}
 		if offer != nil {
 			s.Log.Info("Assigning task to node",
 				"taskID", task.Id,
@@ -117,7 +123,7 @@ func (s *Scheduler) Schedule(ctx context.Context) error {
 				continue
 			}
 
-			err = s.DB.WriteContext(ctx, events.NewState(task.Id, 0, tes.State_INITIALIZING))
+			err = s.DB.WriteContext(ctx, events.NewState(task.Id, task.Attempt(), tes.State_INITIALIZING))
 			if err != nil {
 				s.Log.Error("Error marking task as initializing",
 					"error", err,
@@ -132,6 +138,16 @@ func (s *Scheduler) Schedule(ctx context.Context) error {
 	return nil
 }
 
+// assigned returns true if the task ID is in the node's assigned task IDs.
+func assigned(n *pbs.Node, id string) bool {
+	for _, tid := range n.TaskIds {
+		if tid == id {
+			return true
+		}
+	}
+	return false
+}
+
 // Scale implements some common logic for allowing scheduler backends
 // to poll the database, looking for nodes that need to be started
 // and shutdown.
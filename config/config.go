@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -70,12 +71,14 @@ func DefaultConfig() Config {
 	workDir := path.Join(cwd, "funnel-work-dir")
 
 	server := Server{
-		HostName:         "localhost",
-		HTTPPort:         "8000",
-		RPCPort:          "9090",
-		ServiceName:      "Funnel",
-		DisableHTTPCache: true,
-		Logger:           logger.DefaultConfig(),
+		HostName:           "localhost",
+		HTTPPort:           "8000",
+		RPCPort:            "9090",
+		ServiceName:        "Funnel",
+		DisableHTTPCache:   true,
+		HeartbeatCheckRate: time.Minute,
+		PreflightTimeout:   time.Second * 30,
+		Logger:             logger.DefaultConfig(),
 	}
 
 	c := Config{
@@ -105,10 +108,32 @@ func DefaultConfig() Config {
 				Local: LocalStorage{
 					AllowedDirs: []string{cwd},
 				},
+				Retry: StorageRetryPolicy{
+					MaxAttempts: 3,
+					Backoff:     time.Second,
+					MaxBackoff:  time.Second * 30,
+				},
+			},
+			UpdateRate:           time.Second * 5,
+			HeartbeatRate:        time.Second * 30,
+			BufferSize:           10000,
+			MaxParallelTransfers: 10,
+			ContainerRuntime:     "docker",
+			PullPolicy:           "always",
+			Logger:               logger.DefaultConfig(),
+			InputCache: InputCache{
+				MaxBytes: 100 * 1024 * 1024 * 1024,
+			},
+			WorkDirCleanup: WorkDirCleanup{
+				Delete:    "never",
+				CheckRate: time.Minute,
+			},
+			Retry: RetryPolicy{
+				MaxAttempts: 1,
+				Backoff:     time.Second * 10,
+				MaxBackoff:  time.Minute * 5,
+				States:      []string{"SYSTEM_ERROR"},
 			},
-			UpdateRate: time.Second * 5,
-			BufferSize: 10000,
-			Logger:     logger.DefaultConfig(),
 		},
 	}
 
@@ -186,6 +211,21 @@ type Server struct {
 		MongoDB  MongoDB
 	}
 	DisableHTTPCache bool
+	// How long to wait for a heartbeat from the worker of a running task,
+	// before the task is considered lost, and is failed with SYSTEM_ERROR,
+	// or retried according to Worker.Retry. 0, the default, disables the check.
+	HeartbeatTimeout time.Duration
+	// How often to check the heartbeats of running tasks.
+	HeartbeatCheckRate time.Duration
+	// Check that the inputs of a new task exist, using the storage
+	// configuration in Worker.Storage, and reject the task if they don't.
+	// Requires PreflightURLPrefixes.
+	PreflightInputs bool
+	// URL prefixes of the inputs which are checked, e.g. "s3://bucket/data/".
+	// Other inputs aren't checked.
+	PreflightURLPrefixes []string
+	// How long to wait for the input checks of a new task.
+	PreflightTimeout time.Duration
 	Logger           logger.Config
 }
 
@@ -254,14 +294,76 @@ type Node struct {
 type Worker struct {
 	// Directory to write task files to
 	WorkDir string
+	// ID of the node running the worker, recorded in the task logs.
+	// Set by the node, for backends which use Funnel's scheduler.
+	NodeID string
 	// How often the worker sends task log updates
 	UpdateRate time.Duration
+	// How often the worker sends heartbeats while it runs a task,
+	// see Server.HeartbeatTimeout. 0 disables heartbeats.
+	HeartbeatRate time.Duration
 	// Max bytes to store in-memory between updates
-	BufferSize  int64
-	Storage     StorageConfig
-	Logger      logger.Config
-	TaskReader  string
-	TaskReaders struct {
+	BufferSize int64
+	// Maximum number of input/output files transferred at once.
+	MaxParallelTransfers int
+	// Upload the task's outputs even if an executor failed, e.g. to keep
+	// logs and partial results for debugging. Tasks may override this, or
+	// select a subset of outputs, with the "funnel_upload_on_failure" tag.
+	UploadOutputsOnFailure bool
+	// Checksum algorithm of the uploaded outputs, "md5" or "sha256", recorded
+	// in the task log metadata under "output_checksums". Empty disables output
+	// checksums. Tasks may override this with the "funnel_output_checksum" tag.
+	OutputChecksum string
+	// Storage URL prefix where the full stdout/stderr of every executor
+	// is archived, e.g. "s3://bucket/funnel-logs". Empty disables archiving.
+	ExecutorLogsURL string
+	// Default wall-clock timeouts for a whole task, and for each executor.
+	// 0 means no timeout. Tasks may override these with the "funnel_timeout"
+	// and "funnel_executor_timeout" tags.
+	TaskTimeout     time.Duration
+	ExecutorTimeout time.Duration
+	// The container runtime used to run task executors.
+	// Available runtimes: docker, podman, singularity, apptainer, exec
+	ContainerRuntime  string
+	ContainerRuntimes struct {
+		Singularity struct {
+			// Name or path of the singularity/apptainer binary.
+			// Defaults to the name of the ContainerRuntime.
+			Command string
+		}
+	}
+	// Network mode of executor containers, for the docker and podman
+	// runtimes: none, bridge or host. Empty uses the runtime's default.
+	NetworkMode string
+	// Network modes which tasks may select with the "funnel_network" tag.
+	// Empty by default, so tasks can't change the network mode.
+	AllowedNetworkModes []string
+	// Run executor containers as the worker's UID/GID, instead of the
+	// image's user, so that files written to the task's volumes are owned
+	// by the worker's user. Tasks may also enable this with the
+	// "funnel_run_as_host_user" tag.
+	RunAsHostUser bool
+	// Supplementary groups (names or GIDs) of the executor container's user,
+	// for the docker and podman runtimes.
+	SupplementaryGroups []string
+	// When to pull executor images, for the docker and podman runtimes:
+	// always, if-not-present or never.
+	PullPolicy string
+	// Credentials for pulling images from private registries.
+	RegistryAuth RegistryAuth
+	// Provider of the secrets referenced by executor env values,
+	// e.g. "secret://db-password".
+	Secrets Secrets
+	// Cache of downloaded input files, shared by the workers on a node.
+	InputCache InputCache
+	// Automatic retries of failed tasks.
+	Retry RetryPolicy
+	// Cleanup of task working directories.
+	WorkDirCleanup WorkDirCleanup
+	Storage        StorageConfig
+	Logger         logger.Config
+	TaskReader     string
+	TaskReaders    struct {
 		RPC      RPC
 		DynamoDB DynamoDB
 		Elastic  Elastic
@@ -277,6 +379,96 @@ type Worker struct {
 	}
 }
 
+// RegistryAuth configures the credentials used to pull images
+// from private registries.
+type RegistryAuth struct {
+	// Path to a docker config file, e.g. "~/.docker/config.json",
+	// with credentials for each registry.
+	ConfigFile string
+	// Directory of per-task credential files, in the docker config file
+	// format. A task selects a file by name with the "funnel_registry_auth"
+	// tag, which may also reference a secret, e.g. "secret://registry-auth".
+	// Per-task credentials take precedence over ConfigFile.
+	SecretsDir string
+}
+
+// Secrets configures the provider of the secrets referenced by executor
+// env values.
+type Secrets struct {
+	// The name of the active secret provider. Available providers: file
+	// Empty disables secret references.
+	Provider string
+	File     FileSecrets
+}
+
+// FileSecrets configures the file-based secret provider.
+type FileSecrets struct {
+	// Directory of secret files. Each file holds one secret,
+	// and is named by the secret's name.
+	Dir string
+}
+
+// InputCache configures a node-level cache of downloaded input files.
+// Files are cached by URL and storage version (e.g. ETag), so only
+// storage backends which report object versions (S3, GS, Swift) are cached.
+type InputCache struct {
+	Enabled bool
+	// Directory to store cached files in.
+	// Defaults to "input-cache" in the worker's WorkDir.
+	Dir string
+	// Maximum total size of the cached files, in bytes.
+	// The least recently used files are evicted first.
+	MaxBytes int64
+}
+
+// RetryPolicy configures automatic retries of failed tasks. A retried task
+// is put back in the queue, and each attempt gets its own task log.
+// The server submits a retried task to the compute backend again only if
+// the worker writes events through the server, with the "rpc" event writer.
+type RetryPolicy struct {
+	// Maximum number of times a task is attempted, including the first attempt.
+	// 0 or 1 means failed tasks are not retried.
+	MaxAttempts uint32
+	// How long to wait before requeueing the task. The wait doubles with each
+	// attempt, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Final task states which are retried, e.g. SYSTEM_ERROR, EXECUTOR_ERROR.
+	// A worker which is shut down, e.g. on a preempted VM, fails with SYSTEM_ERROR.
+	States []string
+}
+
+// Retries returns true if the policy retries tasks which end in the given
+// state, e.g. "SYSTEM_ERROR".
+func (p RetryPolicy) Retries(state string) bool {
+	for _, s := range p.States {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkDirCleanup configures when the task working directories in the
+// worker's WorkDir are deleted. Directories which aren't deleted by the worker
+// are deleted by a janitor on the node, according to KeepFor and MaxBytes.
+// The janitor only runs in a node ("funnel node run"), not for workers
+// started by the HPC backends, which only apply Delete.
+type WorkDirCleanup struct {
+	// When the worker deletes a task's working directory, once the task is done:
+	// "never", "always", or "on-success", which keeps the directories of failed
+	// tasks for debugging.
+	Delete string
+	// How long to keep the working directories of finished tasks.
+	// 0 means they're kept until they're deleted manually.
+	KeepFor time.Duration
+	// Maximum total size of the kept working directories, in bytes.
+	// The oldest directories are deleted first. 0 means no limit.
+	MaxBytes int64
+	// How often the node's janitor checks the working directories.
+	CheckRate time.Duration
+}
+
 // RPC configures access to the Funnel RPC server.
 type RPC struct {
 	// RPC address of the Funnel server
@@ -350,6 +542,22 @@ type StorageConfig struct {
 	S3    S3Storage
 	GS    []GSStorage
 	Swift SwiftStorage
+	HTTP  HTTPStorage
+	// Retries of storage requests which fail with a transient error,
+	// for all the storage backends.
+	Retry StorageRetryPolicy
+}
+
+// StorageRetryPolicy configures retries of storage requests which fail with
+// a transient error, e.g. a 503 response or a connection reset.
+type StorageRetryPolicy struct {
+	// Maximum number of times a request is attempted, including the first attempt.
+	// 0 or 1 means failed requests are not retried.
+	MaxAttempts int
+	// How long to wait before the first retry. The wait doubles with each
+	// retry, up to MaxBackoff, and is randomized to spread out retries.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
 }
 
 // LocalStorage describes the directories Funnel can read from and write to
@@ -410,6 +618,27 @@ func (s SwiftStorage) Valid() bool {
 	return !s.Disabled && valid
 }
 
+// HTTPStorage configures the read-only HTTP(S) storage backend,
+// which downloads inputs from http:// and https:// URLs.
+type HTTPStorage struct {
+	Disabled bool
+	// Hosts which inputs can be downloaded from, e.g. "data.example.com",
+	// or "*" for any host. The backend is disabled until this is set.
+	AllowedHosts []string
+	// Optional basic auth credentials.
+	Username string
+	Password string
+	// Optional bearer token, used instead of basic auth if set.
+	BearerToken string
+	// Hosts which the credentials are sent to, over https only.
+	AuthHosts []string
+}
+
+// Valid validates the HTTPStorage configuration.
+func (h HTTPStorage) Valid() bool {
+	return !h.Disabled && len(h.AllowedHosts) > 0
+}
+
 // ToYaml formats the configuration into YAML and returns the bytes.
 func (c Config) ToYaml() []byte {
 	// TODO handle error
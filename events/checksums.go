@@ -0,0 +1,24 @@
+package events
+
+import (
+	"encoding/json"
+)
+
+// OutputChecksumsKey is the TaskLog metadata key under which databases store
+// the checksums of the output files.
+const OutputChecksumsKey = "output_checksums"
+
+// OutputChecksumsMetadata returns the TaskLog metadata key and value used to
+// store the checksums of the outputs event. The second return value is false
+// if the event has no checksums.
+//
+// The TES OutputFileLog doesn't have a field for checksums, so databases
+// store them in the task log's metadata, encoded as a JSON object of
+// "<algorithm>:<hex>" checksums by output file URL.
+func OutputChecksumsMetadata(o *Outputs) (key, value string, ok bool) {
+	if len(o.GetChecksums()) == 0 {
+		return "", "", false
+	}
+	b, _ := json.Marshal(o.GetChecksums())
+	return OutputChecksumsKey, string(b), true
+}
@@ -47,6 +47,11 @@ func (eg *ExecutorGenerator) Stderr(s string) *Event {
 	return NewStderr(eg.taskID, eg.attempt, eg.index, s)
 }
 
+// ResourceUsage updates an executor's resource usage log.
+func (eg *ExecutorGenerator) ResourceUsage(r *ResourceUsage) *Event {
+	return NewResourceUsage(eg.taskID, eg.attempt, eg.index, r)
+}
+
 // Info creates an info level system log message.
 func (eg *ExecutorGenerator) Info(msg string, args ...interface{}) *Event {
 	return eg.sys.Info(msg, args...)
@@ -104,6 +109,11 @@ func (ew *ExecutorWriter) Stderr(s string) error {
 	return ew.out.Write(ew.gen.Stderr(s))
 }
 
+// ResourceUsage updates an executor's resource usage log.
+func (ew *ExecutorWriter) ResourceUsage(r *ResourceUsage) error {
+	return ew.out.Write(ew.gen.ResourceUsage(r))
+}
+
 // Info writes an info level system log message.
 func (ew *ExecutorWriter) Info(msg string, args ...interface{}) error {
 	return ew.sys.Info(msg, args...)
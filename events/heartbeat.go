@@ -0,0 +1,57 @@
+package events
+
+import (
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"time"
+)
+
+// HeartbeatKey is the TaskLog metadata key under which databases store
+// the time of the last heartbeat sent by the task's worker.
+const HeartbeatKey = "worker_heartbeat"
+
+// HeartbeatMetadata returns the TaskLog metadata key and value used to
+// store the given heartbeat event.
+//
+// The TES TaskLog doesn't have a field for heartbeats, so databases store
+// the timestamp of the last heartbeat in the task log's metadata.
+func HeartbeatMetadata(ev *Event) (key, value string) {
+	return HeartbeatKey, ev.Timestamp
+}
+
+// LastHeartbeat returns the time of the last heartbeat sent by the worker
+// running the task's current attempt. The second return value is false if
+// the worker hasn't sent any heartbeats, e.g. because heartbeats are disabled.
+func LastHeartbeat(task *tes.Task) (time.Time, bool) {
+	if len(task.Logs) == 0 {
+		return time.Time{}, false
+	}
+	tl := task.Logs[len(task.Logs)-1]
+	v, ok := tl.Metadata[HeartbeatKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// WorkerLostMsg is the message of the system log written when the worker of
+// a task's attempt is lost.
+//
+// Databases don't store system logs, except this one, so that users can see
+// why the task failed.
+const WorkerLostMsg = "No heartbeat from the task's worker within the heartbeat timeout, the worker may be lost"
+
+// NewWorkerLost creates a system log event explaining that the worker
+// running the given attempt of the task is lost.
+func NewWorkerLost(taskID string, attempt uint32, fields map[string]string) *Event {
+	return NewSystemLog(taskID, attempt, 0, "error", WorkerLostMsg, fields)
+}
+
+// IsWorkerLost returns true if the event is a system log created by
+// NewWorkerLost.
+func IsWorkerLost(ev *Event) bool {
+	return ev.Type == Type_SYSTEM_LOG && ev.GetSystemLog().GetMsg() == WorkerLostMsg
+}
@@ -40,6 +40,10 @@ func (el *Logger) Write(ev *Event) error {
 		log.Info(ts, "stdout", ev.GetStdout())
 	case Type_EXECUTOR_STDERR:
 		log.Info(ts, "stderr", ev.GetStderr())
+	case Type_EXECUTOR_RESOURCE_USAGE:
+		log.Info(ts, "resource_usage", ev.GetResourceUsage())
+	case Type_TASK_HEARTBEAT:
+		log.Debug(ts)
 	case Type_SYSTEM_LOG:
 		var args []interface{}
 		for k, v := range ev.GetSystemLog().Fields {
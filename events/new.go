@@ -44,8 +44,9 @@ func NewEndTime(taskID string, attempt uint32, t time.Time) *Event {
 	}
 }
 
-// NewOutputs creates a task output file log event.
-func NewOutputs(taskID string, attempt uint32, f []*tes.OutputFileLog) *Event {
+// NewOutputs creates a task output file log event. "checksums" are the
+// optional checksums of the output files, by URL, as "<algorithm>:<hex>".
+func NewOutputs(taskID string, attempt uint32, f []*tes.OutputFileLog, checksums map[string]string) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
@@ -53,7 +54,8 @@ func NewOutputs(taskID string, attempt uint32, f []*tes.OutputFileLog) *Event {
 		Attempt:   attempt,
 		Data: &Event_Outputs{
 			Outputs: &Outputs{
-				Value: f,
+				Value:     f,
+				Checksums: checksums,
 			},
 		},
 	}
@@ -149,6 +151,32 @@ func NewStderr(taskID string, attempt uint32, index uint32, s string) *Event {
 	}
 }
 
+// NewResourceUsage creates an executor resource usage event
+// for the executor at the given index.
+func NewResourceUsage(taskID string, attempt uint32, index uint32, r *ResourceUsage) *Event {
+	return &Event{
+		Id:        taskID,
+		Timestamp: time.Now().Format(time.RFC3339Nano),
+		Type:      Type_EXECUTOR_RESOURCE_USAGE,
+		Attempt:   attempt,
+		Index:     index,
+		Data: &Event_ResourceUsage{
+			ResourceUsage: r,
+		},
+	}
+}
+
+// NewHeartbeat creates a task heartbeat event, which the worker sends
+// periodically while it is running the task.
+func NewHeartbeat(taskID string, attempt uint32) *Event {
+	return &Event{
+		Id:        taskID,
+		Timestamp: time.Now().Format(time.RFC3339Nano),
+		Type:      Type_TASK_HEARTBEAT,
+		Attempt:   attempt,
+	}
+}
+
 // NewSystemLog creates an system log event.
 func NewSystemLog(taskID string, attempt uint32, index uint32, lvl string, msg string, fields map[string]string) *Event {
 	return &Event{
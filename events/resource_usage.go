@@ -0,0 +1,23 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ResourceUsageMetadata returns the TaskLog metadata key and value used to
+// store the resource usage of the executor at the given index.
+//
+// The TES ExecutorLog doesn't have a field for resource usage, and it is
+// defined by the GA4GH schema (proto/tes/task-execution-schemas), so adding
+// one would diverge from the TES API that clients expect. Instead, databases
+// store it in the task log's metadata, under a key per executor, e.g.
+// "executor_0_resource_usage", with the usage encoded as JSON.
+func ResourceUsageMetadata(index uint32, r *ResourceUsage) (key, value string) {
+	key = fmt.Sprintf("executor_%d_resource_usage", index)
+	if r == nil {
+		r = &ResourceUsage{}
+	}
+	b, _ := json.Marshal(r)
+	return key, string(b)
+}
@@ -3,6 +3,8 @@ package events
 import (
 	"fmt"
 	"github.com/ohsu-comp-bio/funnel/util"
+	"sort"
+	"strings"
 )
 
 // SystemLogGenerator is a type that emulates the logger interface
@@ -68,3 +70,28 @@ func fields(args ...interface{}) map[string]string {
 	}
 	return ss
 }
+
+// SysLogString formats a system log event as a single line, e.g.
+// `level="error" msg="System error" error="..."`, which is how system logs
+// are stored in TaskLog.SystemLogs. Fields are sorted by key.
+// SysLogString returns an empty string for other event types.
+func (ev *Event) SysLogString() string {
+	if ev.Type != Type_SYSTEM_LOG {
+		return ""
+	}
+	sl := ev.GetSystemLog()
+	parts := []string{
+		fmt.Sprintf("level=%q", sl.Level),
+		fmt.Sprintf("msg=%q", sl.Msg),
+	}
+
+	keys := make([]string, 0, len(sl.Fields))
+	for k := range sl.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, sl.Fields[k]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -23,6 +23,11 @@ func (eg *TaskGenerator) State(s tes.State) *Event {
 	return NewState(eg.taskID, eg.attempt, s)
 }
 
+// Requeue puts the task back in the queue, to be retried as the next attempt.
+func (eg *TaskGenerator) Requeue() *Event {
+	return NewState(eg.taskID, eg.attempt+1, tes.State_QUEUED)
+}
+
 // StartTime updates the task's start time log.
 func (eg *TaskGenerator) StartTime(t time.Time) *Event {
 	return NewStartTime(eg.taskID, eg.attempt, t)
@@ -33,9 +38,10 @@ func (eg *TaskGenerator) EndTime(t time.Time) *Event {
 	return NewEndTime(eg.taskID, eg.attempt, t)
 }
 
-// Outputs updates the task's output file log.
-func (eg *TaskGenerator) Outputs(f []*tes.OutputFileLog) *Event {
-	return NewOutputs(eg.taskID, eg.attempt, f)
+// Outputs updates the task's output file log, and the optional
+// checksums of the output files, by URL.
+func (eg *TaskGenerator) Outputs(f []*tes.OutputFileLog, checksums map[string]string) *Event {
+	return NewOutputs(eg.taskID, eg.attempt, f, checksums)
 }
 
 // Metadata updates the task's metadata log.
@@ -43,6 +49,11 @@ func (eg *TaskGenerator) Metadata(m map[string]string) *Event {
 	return NewMetadata(eg.taskID, eg.attempt, m)
 }
 
+// Heartbeat signals that the worker running the task is still alive.
+func (eg *TaskGenerator) Heartbeat() *Event {
+	return NewHeartbeat(eg.taskID, eg.attempt)
+}
+
 // Info creates an info level system log message.
 func (eg *TaskGenerator) Info(msg string, args ...interface{}) *Event {
 	return eg.sys.Info(msg, args...)
@@ -80,6 +91,22 @@ func (ew *TaskWriter) State(s tes.State) error {
 	return ew.out.Write(ew.gen.State(s))
 }
 
+// Requeue puts the task back in the queue, to be retried as the next attempt.
+func (ew *TaskWriter) Requeue() error {
+	return ew.out.Write(ew.gen.Requeue())
+}
+
+// Attempt returns the task attempt the writer generates events for.
+func (ew *TaskWriter) Attempt() uint32 {
+	return ew.gen.attempt
+}
+
+// WithAttempt returns a new TaskWriter, which writes events for the given
+// attempt to the same underlying writer.
+func (ew *TaskWriter) WithAttempt(attempt uint32) *TaskWriter {
+	return NewTaskWriter(ew.gen.taskID, attempt, ew.sys.lvl, ew.out)
+}
+
 // StartTime updates the task's start time log.
 func (ew *TaskWriter) StartTime(t time.Time) error {
 	return ew.out.Write(ew.gen.StartTime(t))
@@ -90,9 +117,10 @@ func (ew *TaskWriter) EndTime(t time.Time) error {
 	return ew.out.Write(ew.gen.EndTime(t))
 }
 
-// Outputs updates the task's output file log.
-func (ew *TaskWriter) Outputs(f []*tes.OutputFileLog) error {
-	return ew.out.Write(ew.gen.Outputs(f))
+// Outputs updates the task's output file log, and the optional
+// checksums of the output files, by URL.
+func (ew *TaskWriter) Outputs(f []*tes.OutputFileLog, checksums map[string]string) error {
+	return ew.out.Write(ew.gen.Outputs(f, checksums))
 }
 
 // Metadata updates the task's metadata log.
@@ -100,6 +128,11 @@ func (ew *TaskWriter) Metadata(m map[string]string) error {
 	return ew.out.Write(ew.gen.Metadata(m))
 }
 
+// Heartbeat signals that the worker running the task is still alive.
+func (ew *TaskWriter) Heartbeat() error {
+	return ew.out.Write(ew.gen.Heartbeat())
+}
+
 // Info creates an info level system log message.
 func (ew *TaskWriter) Info(msg string, args ...interface{}) error {
 	return ew.sys.Info(msg, args...)
@@ -19,7 +19,13 @@ func (tb TaskBuilder) Write(ev *Event) error {
 	switch ev.Type {
 	case Type_TASK_STATE:
 		to := ev.GetState()
-		if err := tes.ValidateTransition(t.GetState(), ev.GetState()); err != nil {
+		if to == tes.State_QUEUED && tes.RunnableState(t.GetState()) {
+			// The task is being retried. Start a new task log for the attempt.
+			if err := tes.ValidateRequeue(t, ev.Attempt); err != nil {
+				return err
+			}
+			t.GetTaskLog(attempt)
+		} else if err := tes.ValidateTransition(t.GetState(), ev.GetState()); err != nil {
 			return err
 		}
 		t.State = to
@@ -31,10 +37,23 @@ func (tb TaskBuilder) Write(ev *Event) error {
 		t.GetTaskLog(attempt).EndTime = ev.GetEndTime()
 
 	case Type_TASK_OUTPUTS:
-		t.GetTaskLog(attempt).Outputs = ev.GetOutputs().Value
+		tl := t.GetTaskLog(attempt)
+		tl.Outputs = ev.GetOutputs().Value
+		if k, v, ok := OutputChecksumsMetadata(ev.GetOutputs()); ok {
+			if tl.Metadata == nil {
+				tl.Metadata = map[string]string{}
+			}
+			tl.Metadata[k] = v
+		}
 
 	case Type_TASK_METADATA:
-		t.GetTaskLog(attempt).Metadata = ev.GetMetadata().Value
+		tl := t.GetTaskLog(attempt)
+		if tl.Metadata == nil {
+			tl.Metadata = map[string]string{}
+		}
+		for k, v := range ev.GetMetadata().Value {
+			tl.Metadata[k] = v
+		}
 
 	case Type_EXECUTOR_START_TIME:
 		t.GetExecLog(attempt, index).StartTime = ev.GetStartTime()
@@ -50,6 +69,29 @@ func (tb TaskBuilder) Write(ev *Event) error {
 
 	case Type_EXECUTOR_STDERR:
 		t.GetExecLog(attempt, index).Stderr += ev.GetStderr()
+
+	case Type_EXECUTOR_RESOURCE_USAGE:
+		tl := t.GetTaskLog(attempt)
+		if tl.Metadata == nil {
+			tl.Metadata = map[string]string{}
+		}
+		k, v := ResourceUsageMetadata(ev.Index, ev.GetResourceUsage())
+		tl.Metadata[k] = v
+
+	case Type_SYSTEM_LOG:
+		if !IsWorkerLost(ev) {
+			return nil
+		}
+		tl := t.GetTaskLog(attempt)
+		tl.SystemLogs = append(tl.SystemLogs, ev.SysLogString())
+
+	case Type_TASK_HEARTBEAT:
+		tl := t.GetTaskLog(attempt)
+		if tl.Metadata == nil {
+			tl.Metadata = map[string]string{}
+		}
+		k, v := HeartbeatMetadata(ev)
+		tl.Metadata[k] = v
 	}
 
 	return nil
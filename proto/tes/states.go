@@ -27,8 +27,9 @@ func ValidateTransition(from, to State) error {
 		return nil
 	}
 
-	if from == Paused || to == Paused {
-		return fmt.Errorf("paused state is not implemented")
+	// Only running tasks may be paused.
+	if to == Paused && from != Running {
+		return transitionError(from, to)
 	}
 
 	switch from {
@@ -57,7 +58,16 @@ func ValidateTransition(from, to State) error {
 		switch to {
 		case Unknown, Queued:
 			return transitionError(from, to)
-		case Complete, ExecutorError, SystemError, Canceled:
+		case Paused, Complete, ExecutorError, SystemError, Canceled:
+			return nil
+		}
+
+	case Paused:
+
+		switch to {
+		case Unknown, Queued, Initializing:
+			return transitionError(from, to)
+		case Running, Complete, ExecutorError, SystemError, Canceled:
 			return nil
 		}
 
@@ -71,3 +81,17 @@ func ValidateTransition(from, to State) error {
 	// Shouldn't be reaching this point, but just in case.
 	return transitionError(from, to)
 }
+
+// ValidateRequeue validates a transition back to the Queued state, which
+// retries a task. "attempt" is the index of the new attempt, which must follow
+// the task's current attempt.
+func ValidateRequeue(task *Task, attempt uint32) error {
+	if !RunnableState(task.GetState()) {
+		return transitionError(task.GetState(), Queued)
+	}
+	if attempt != task.Attempt()+1 {
+		return fmt.Errorf("can't requeue attempt %d of task %s, the current attempt is %d",
+			attempt, task.Id, task.Attempt())
+	}
+	return nil
+}
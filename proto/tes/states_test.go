@@ -0,0 +1,35 @@
+package tes
+
+import "testing"
+
+func TestValidateTransitionPaused(t *testing.T) {
+	valid := [][2]State{
+		{Running, Paused},
+		{Paused, Running},
+		{Paused, Complete},
+		{Paused, ExecutorError},
+		{Paused, SystemError},
+		{Paused, Canceled},
+	}
+	for _, v := range valid {
+		if err := ValidateTransition(v[0], v[1]); err != nil {
+			t.Errorf("expected %s -> %s to be valid: %s", v[0], v[1], err)
+		}
+	}
+
+	invalid := [][2]State{
+		{Unknown, Paused},
+		{Queued, Paused},
+		{Initializing, Paused},
+		{Complete, Paused},
+		{Canceled, Paused},
+		{Paused, Queued},
+		{Paused, Initializing},
+		{Paused, Unknown},
+	}
+	for _, v := range invalid {
+		if err := ValidateTransition(v[0], v[1]); err == nil {
+			t.Errorf("expected %s -> %s to be invalid", v[0], v[1])
+		}
+	}
+}
@@ -92,6 +92,15 @@ func (task *Task) GetTaskLog(i int) *TaskLog {
 	return task.Logs[i]
 }
 
+// Attempt returns the index of the task's current attempt.
+// Each attempt has its own entry in the task logs.
+func (task *Task) Attempt() uint32 {
+	if task == nil || len(task.Logs) == 0 {
+		return 0
+	}
+	return uint32(len(task.Logs) - 1)
+}
+
 // GetExecLog gets the executor log entry at the given index "i".
 // If the entry doesn't exist, empty logs will be appended up to "i".
 func (task *Task) GetExecLog(attempt int, i int) *ExecutorLog {
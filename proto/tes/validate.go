@@ -2,6 +2,7 @@ package tes
 
 import (
 	"fmt"
+	"path"
 	"strings"
 )
 
@@ -82,6 +83,10 @@ func Validate(t *Task) ValidationError {
 		if output.Path != "" && !strings.HasPrefix(output.Path, "/") {
 			errs.add("task.Outputs[%d].Path: must be an absolute path", i)
 		}
+
+		if _, err := path.Match(output.Path, ""); err != nil {
+			errs.add("Task.Outputs[%d].Path: invalid glob pattern", i)
+		}
 	}
 
 	for i, vol := range t.Volumes {
@@ -28,13 +28,18 @@ func (taskBolt *BoltDB) CreateEvent(ctx context.Context, req *events.Event) (*ev
 }
 
 // Write writes task events to the database, updating the task record they
-// are related to. System log events are ignored.
+// are related to. System log events are ignored, except the one explaining
+// that the task's worker is lost.
 func (taskBolt *BoltDB) Write(req *events.Event) error {
 	return taskBolt.WriteContext(context.Background(), req)
 }
 
 // WriteContext is Write, but with context.
 func (taskBolt *BoltDB) WriteContext(ctx context.Context, req *events.Event) error {
+	if req.Type == events.Type_SYSTEM_LOG && !events.IsWorkerLost(req) {
+		return nil
+	}
+
 	var err error
 
 	tl := &tes.TaskLog{}
@@ -42,6 +47,9 @@ func (taskBolt *BoltDB) WriteContext(ctx context.Context, req *events.Event) err
 
 	switch req.Type {
 	case events.Type_TASK_STATE:
+		if req.GetState() == Queued {
+			return taskBolt.requeue(ctx, req.Id, req.Attempt)
+		}
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
 			return transitionTaskState(tx, req.Id, req.GetState())
 		})
@@ -49,53 +57,76 @@ func (taskBolt *BoltDB) WriteContext(ctx context.Context, req *events.Event) err
 	case events.Type_TASK_START_TIME:
 		tl.StartTime = req.GetStartTime()
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateTaskLogs(tx, req.Id, tl)
+			return updateTaskLogs(tx, taskLogKey(req.Id, req.Attempt), tl)
 		})
 
 	case events.Type_TASK_END_TIME:
 		tl.EndTime = req.GetEndTime()
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateTaskLogs(tx, req.Id, tl)
+			return updateTaskLogs(tx, taskLogKey(req.Id, req.Attempt), tl)
 		})
 
 	case events.Type_TASK_OUTPUTS:
 		tl.Outputs = req.GetOutputs().Value
+		if k, v, ok := events.OutputChecksumsMetadata(req.GetOutputs()); ok {
+			tl.Metadata = map[string]string{k: v}
+		}
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateTaskLogs(tx, req.Id, tl)
+			return updateTaskLogs(tx, taskLogKey(req.Id, req.Attempt), tl)
 		})
 
 	case events.Type_TASK_METADATA:
 		tl.Metadata = req.GetMetadata().Value
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateTaskLogs(tx, req.Id, tl)
+			return updateTaskLogs(tx, taskLogKey(req.Id, req.Attempt), tl)
+		})
+
+	case events.Type_EXECUTOR_RESOURCE_USAGE:
+		k, v := events.ResourceUsageMetadata(req.Index, req.GetResourceUsage())
+		tl.Metadata = map[string]string{k: v}
+		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
+			return updateTaskLogs(tx, taskLogKey(req.Id, req.Attempt), tl)
+		})
+
+	case events.Type_SYSTEM_LOG:
+		tl.SystemLogs = []string{req.SysLogString()}
+		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
+			return updateTaskLogs(tx, taskLogKey(req.Id, req.Attempt), tl)
+		})
+
+	case events.Type_TASK_HEARTBEAT:
+		k, v := events.HeartbeatMetadata(req)
+		tl.Metadata = map[string]string{k: v}
+		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
+			return updateTaskLogs(tx, taskLogKey(req.Id, req.Attempt), tl)
 		})
 
 	case events.Type_EXECUTOR_START_TIME:
 		el.StartTime = req.GetStartTime()
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateExecutorLogs(tx, fmt.Sprint(req.Id, req.Index), el)
+			return updateExecutorLogs(tx, executorLogKey(req.Id, req.Attempt, req.Index), el)
 		})
 
 	case events.Type_EXECUTOR_END_TIME:
 		el.EndTime = req.GetEndTime()
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateExecutorLogs(tx, fmt.Sprint(req.Id, req.Index), el)
+			return updateExecutorLogs(tx, executorLogKey(req.Id, req.Attempt, req.Index), el)
 		})
 
 	case events.Type_EXECUTOR_EXIT_CODE:
 		el.ExitCode = req.GetExitCode()
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateExecutorLogs(tx, fmt.Sprint(req.Id, req.Index), el)
+			return updateExecutorLogs(tx, executorLogKey(req.Id, req.Attempt, req.Index), el)
 		})
 
 	case events.Type_EXECUTOR_STDOUT:
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateExecutorStdout(tx, fmt.Sprint(req.Id, req.Index), req.GetStdout())
+			return updateExecutorStdout(tx, executorLogKey(req.Id, req.Attempt, req.Index), req.GetStdout())
 		})
 
 	case events.Type_EXECUTOR_STDERR:
 		err = taskBolt.db.Update(func(tx *bolt.Tx) error {
-			return updateExecutorStderr(tx, fmt.Sprint(req.Id, req.Index), req.GetStderr())
+			return updateExecutorStderr(tx, executorLogKey(req.Id, req.Attempt, req.Index), req.GetStderr())
 		})
 	}
 
@@ -121,18 +152,30 @@ func transitionTaskState(tx *bolt.Tx, id string, target tes.State) error {
 		return fmt.Errorf("Unexpected transition from %s to %s", current.String(), target.String())
 
 	case target == Queued:
-		return fmt.Errorf("Can't transition to Queued state")
+		return fmt.Errorf("Can't transition to Queued state, except to retry the task")
 	}
 
 	switch target {
-	case Unknown, Paused:
+	case Unknown:
 		return fmt.Errorf("Unimplemented task state %s", target.String())
 
+	case Paused:
+		if current != Running {
+			return fmt.Errorf("Unexpected transition from %s to %s", current.String(), target.String())
+		}
+
 	case Canceled, Complete, ExecutorError, SystemError:
 		// Remove from queue
 		tx.Bucket(TasksQueued).Delete(idBytes)
 
-	case Running, Initializing:
+	case Running:
+		// A paused task is resumed by moving it back to Running.
+		if current != Unknown && current != Queued && current != Initializing && current != Paused {
+			return fmt.Errorf("Unexpected transition from %s to %s", current.String(), target.String())
+		}
+		tx.Bucket(TasksQueued).Delete(idBytes)
+
+	case Initializing:
 		if current != Unknown && current != Queued && current != Initializing {
 			return fmt.Errorf("Unexpected transition from %s to %s", current.String(), target.String())
 		}
@@ -146,6 +189,70 @@ func transitionTaskState(tx *bolt.Tx, id string, target tes.State) error {
 	return nil
 }
 
+// requeue puts a task back in the queue to retry it, starting the given
+// attempt. The task is submitted to the compute backend again. Without
+// a compute backend, e.g. when a worker writes events directly to the
+// database, the task is only put back in the queue, where Funnel's
+// scheduler finds it.
+func (taskBolt *BoltDB) requeue(ctx context.Context, id string, attempt uint32) error {
+	var task *tes.Task
+	err := taskBolt.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		task, err = getTaskView(tx, id, tes.TaskView_BASIC)
+		if err != nil {
+			return err
+		}
+		if err := tes.ValidateRequeue(task, attempt); err != nil {
+			return err
+		}
+		idBytes := []byte(id)
+		tx.Bucket(TaskAttempts).Put(idBytes, []byte(fmt.Sprint(attempt)))
+		tx.Bucket(TaskState).Put(idBytes, []byte(Queued.String()))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if taskBolt.backend == nil {
+		return nil
+	}
+
+	task.State = Queued
+	task.Logs = append(task.Logs, &tes.TaskLog{})
+	err = taskBolt.backend.Submit(task)
+	if err != nil {
+		err = fmt.Errorf("error resubmitting task to compute backend: %s", err)
+		taskBolt.db.Update(func(tx *bolt.Tx) error {
+			return transitionTaskState(tx, id, SystemError)
+		})
+		return err
+	}
+	return nil
+}
+
+// taskLogKey returns the key of a task log in the TasksLog bucket.
+// Keys of the first attempt are the task ID, for compatibility with databases
+// written before retries were supported.
+func taskLogKey(id string, attempt uint32) string {
+	if attempt == 0 {
+		return id
+	}
+	return fmt.Sprintf("%s-%d", id, attempt)
+}
+
+// executorLogKey returns the key of an executor log in the ExecutorLogs,
+// ExecutorStdout and ExecutorStderr buckets. Keys of the first attempt are
+// the task ID followed by the index, as before retries were supported.
+// Keys of later attempts separate the index, so that e.g. attempt 1,
+// executor 10 and attempt 11, executor 0 have different keys.
+func executorLogKey(id string, attempt, index uint32) string {
+	if attempt == 0 {
+		return fmt.Sprint(id, index)
+	}
+	return fmt.Sprintf("%s-%d", taskLogKey(id, attempt), index)
+}
+
 func updateTaskLogs(tx *bolt.Tx, id string, tl *tes.TaskLog) error {
 	tasklog := &tes.TaskLog{}
 
@@ -179,6 +286,9 @@ func updateTaskLogs(tx *bolt.Tx, id string, tl *tes.TaskLog) error {
 		}
 	}
 
+	// System logs are appended.
+	tasklog.SystemLogs = append(tasklog.SystemLogs, tl.SystemLogs...)
+
 	logbytes, err := proto.Marshal(tasklog)
 	if err != nil {
 		return err
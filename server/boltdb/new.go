@@ -22,17 +22,21 @@ var TasksQueued = []byte("tasks-queued")
 // TaskState maps: task ID -> state string
 var TaskState = []byte("tasks-state")
 
+// TaskAttempts maps: task ID -> index of the task's current attempt.
+// Tasks which haven't been retried have no entry.
+var TaskAttempts = []byte("tasks-attempts")
+
 // TasksLog defines the name of a bucket which maps
-// task ID -> tes.TaskLog struct
+// task ID (+ attempt) -> tes.TaskLog struct
 var TasksLog = []byte("tasks-log")
 
-// ExecutorLogs maps (task ID + executor index) -> tes.ExecutorLog struct
+// ExecutorLogs maps (task ID + attempt + executor index) -> tes.ExecutorLog struct
 var ExecutorLogs = []byte("executor-logs")
 
-// ExecutorStdout maps (task ID + executor index) -> tes.ExecutorLog.Stdout string
+// ExecutorStdout maps (task ID + attempt + executor index) -> tes.ExecutorLog.Stdout string
 var ExecutorStdout = []byte("executor-stdout")
 
-// ExecutorStderr maps (task ID + executor index) -> tes.ExecutorLog.Stderr string
+// ExecutorStderr maps (task ID + attempt + executor index) -> tes.ExecutorLog.Stderr string
 var ExecutorStderr = []byte("executor-stderr")
 
 // Nodes maps:
@@ -74,6 +78,9 @@ func (taskBolt *BoltDB) Init(ctx context.Context) error {
 		if tx.Bucket(TaskState) == nil {
 			tx.CreateBucket(TaskState)
 		}
+		if tx.Bucket(TaskAttempts) == nil {
+			tx.CreateBucket(TaskAttempts)
+		}
 		if tx.Bucket(TasksLog) == nil {
 			tx.CreateBucket(TasksLog)
 		}
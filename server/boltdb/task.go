@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	proto "github.com/golang/protobuf/proto"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"strconv"
 )
 
 // CreateTask provides an HTTP/gRPC endpoint for creating a task.
@@ -79,6 +81,11 @@ func loadBasicTaskView(tx *bolt.Tx, id string, task *tes.Task) error {
 	proto.Unmarshal(b, task)
 	loadTaskLogs(tx, task)
 
+	// remove system logs
+	for _, tl := range task.Logs {
+		tl.SystemLogs = nil
+	}
+
 	// remove content from inputs
 	inputs := []*tes.Input{}
 	for _, v := range task.Inputs {
@@ -99,9 +106,9 @@ func loadFullTaskView(tx *bolt.Tx, id string, task *tes.Task) error {
 	loadTaskLogs(tx, task)
 
 	// Load executor stdout/err
-	for _, tl := range task.Logs {
+	for i, tl := range task.Logs {
 		for j, el := range tl.Logs {
-			key := fmt.Sprint(id, j)
+			key := executorLogKey(id, uint32(i), uint32(j))
 
 			b := tx.Bucket(ExecutorStdout).Get([]byte(key))
 			if b != nil {
@@ -119,24 +126,39 @@ func loadFullTaskView(tx *bolt.Tx, id string, task *tes.Task) error {
 }
 
 func loadTaskLogs(tx *bolt.Tx, task *tes.Task) {
-	tasklog := &tes.TaskLog{}
-	task.Logs = []*tes.TaskLog{tasklog}
+	task.Logs = nil
 
-	b := tx.Bucket(TasksLog).Get([]byte(task.Id))
-	if b != nil {
-		proto.Unmarshal(b, tasklog)
-	}
+	// Load a task log for each attempt.
+	for a := uint32(0); a <= getTaskAttempt(tx, task.Id); a++ {
+		tasklog := &tes.TaskLog{}
+		task.Logs = append(task.Logs, tasklog)
 
-	for i := range task.Executors {
-		o := tx.Bucket(ExecutorLogs).Get([]byte(fmt.Sprint(task.Id, i)))
-		if o != nil {
-			var execlog tes.ExecutorLog
-			proto.Unmarshal(o, &execlog)
-			tasklog.Logs = append(tasklog.Logs, &execlog)
+		b := tx.Bucket(TasksLog).Get([]byte(taskLogKey(task.Id, a)))
+		if b != nil {
+			proto.Unmarshal(b, tasklog)
+		}
+
+		for i := range task.Executors {
+			o := tx.Bucket(ExecutorLogs).Get([]byte(executorLogKey(task.Id, a, uint32(i))))
+			if o != nil {
+				var execlog tes.ExecutorLog
+				proto.Unmarshal(o, &execlog)
+				tasklog.Logs = append(tasklog.Logs, &execlog)
+			}
 		}
 	}
 }
 
+// getTaskAttempt returns the index of the task's current attempt.
+func getTaskAttempt(tx *bolt.Tx, id string) uint32 {
+	b := tx.Bucket(TaskAttempts).Get([]byte(id))
+	if b == nil {
+		return 0
+	}
+	a, _ := strconv.ParseUint(string(b), 10, 32)
+	return uint32(a)
+}
+
 // GetTask gets a task, which describes a running task
 func (taskBolt *BoltDB) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.Task, error) {
 	var task *tes.Task
@@ -240,6 +262,50 @@ func (taskBolt *BoltDB) CancelTask(ctx context.Context, req *tes.CancelTaskReque
 	return &tes.CancelTaskResponse{}, nil
 }
 
+// PauseTask pauses a running task. The task's worker freezes the running
+// executor when it sees the PAUSED state.
+func (taskBolt *BoltDB) PauseTask(ctx context.Context, req *control.PauseTaskRequest) (*control.PauseTaskResponse, error) {
+	err := taskBolt.db.Update(func(tx *bolt.Tx) error {
+		_, err := getTaskView(tx, req.Id, tes.TaskView_MINIMAL)
+		if err != nil {
+			return err
+		}
+		err = transitionTaskState(tx, req.Id, tes.State_PAUSED)
+		if err != nil {
+			return grpc.Errorf(codes.FailedPrecondition, err.Error())
+		}
+		return nil
+	})
+	if err == errNotFound {
+		return nil, grpc.Errorf(codes.NotFound, fmt.Sprintf("%v: taskID: %s", err.Error(), req.Id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &control.PauseTaskResponse{}, nil
+}
+
+// ResumeTask resumes a paused task, moving it back to the RUNNING state.
+func (taskBolt *BoltDB) ResumeTask(ctx context.Context, req *control.ResumeTaskRequest) (*control.ResumeTaskResponse, error) {
+	err := taskBolt.db.Update(func(tx *bolt.Tx) error {
+		_, err := getTaskView(tx, req.Id, tes.TaskView_MINIMAL)
+		if err != nil {
+			return err
+		}
+		if state := getTaskState(tx, req.Id); state != tes.State_PAUSED {
+			return grpc.Errorf(codes.FailedPrecondition, "task %s isn't paused, its state is %s", req.Id, state)
+		}
+		return transitionTaskState(tx, req.Id, tes.State_RUNNING)
+	})
+	if err == errNotFound {
+		return nil, grpc.Errorf(codes.NotFound, fmt.Sprintf("%v: taskID: %s", err.Error(), req.Id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &control.ResumeTaskResponse{}, nil
+}
+
 // GetServiceInfo provides an endpoint for Funnel clients to get information about this server.
 func (taskBolt *BoltDB) GetServiceInfo(ctx context.Context, info *tes.ServiceInfoRequest) (*tes.ServiceInfo, error) {
 	return &tes.ServiceInfo{Name: taskBolt.conf.Server.ServiceName}, nil
@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/ohsu-comp-bio/funnel/compute"
 	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	pbs "github.com/ohsu-comp-bio/funnel/proto/scheduler"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"golang.org/x/net/context"
@@ -14,6 +15,7 @@ type Database interface {
 	tes.TaskServiceServer
 	events.EventServiceServer
 	pbs.SchedulerServiceServer
+	control.ControlServiceServer
 	WithComputeBackend(compute.Backend)
 	Init(context.Context) error
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"golang.org/x/net/context"
 	"strconv"
+	"strings"
 )
 
 // CreateEvent creates an event for the server to handle.
@@ -18,13 +19,22 @@ func (db *DynamoDB) CreateEvent(ctx context.Context, req *events.Event) (*events
 }
 
 // Write writes task events to the database, updating the task record they
-// are related to. System log events are ignored.
+// are related to. System log events are ignored, except the one explaining
+// that the task's worker is lost.
 func (db *DynamoDB) Write(req *events.Event) error {
 	return db.WriteContext(context.Background(), req)
 }
 
 // WriteContext is Write, but with context.
 func (db *DynamoDB) WriteContext(ctx context.Context, e *events.Event) error {
+	if e.Type == events.Type_SYSTEM_LOG && !events.IsWorkerLost(e) {
+		return nil
+	}
+
+	if e.Type == events.Type_TASK_STATE && e.GetState() == tes.State_QUEUED {
+		return db.requeue(ctx, e.Id, e.Attempt)
+	}
+
 	item := &dynamodb.UpdateItemInput{
 		TableName: aws.String(db.taskTable),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -105,29 +115,47 @@ func (db *DynamoDB) WriteContext(ctx context.Context, e *events.Event) error {
 			}
 
 		case tes.State_RUNNING:
-			item.ConditionExpression = aws.String("#state = :from")
+			// A paused task is resumed by moving it back to RUNNING.
+			item.ConditionExpression = aws.String("#state IN (:i, :p)")
 			item.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
 				":to": {
 					N: aws.String(strconv.Itoa(int(tes.State_RUNNING))),
 				},
-				":from": {
+				":i": {
 					N: aws.String(strconv.Itoa(int(tes.State_INITIALIZING))),
 				},
+				":p": {
+					N: aws.String(strconv.Itoa(int(tes.State_PAUSED))),
+				},
 			}
 
-		case tes.State_COMPLETE:
+		case tes.State_PAUSED:
 			item.ConditionExpression = aws.String("#state = :from")
 			item.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
 				":to": {
-					N: aws.String(strconv.Itoa(int(tes.State_COMPLETE))),
+					N: aws.String(strconv.Itoa(int(tes.State_PAUSED))),
 				},
 				":from": {
 					N: aws.String(strconv.Itoa(int(tes.State_RUNNING))),
 				},
 			}
 
+		case tes.State_COMPLETE:
+			item.ConditionExpression = aws.String("#state IN (:r, :p)")
+			item.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+				":to": {
+					N: aws.String(strconv.Itoa(int(tes.State_COMPLETE))),
+				},
+				":r": {
+					N: aws.String(strconv.Itoa(int(tes.State_RUNNING))),
+				},
+				":p": {
+					N: aws.String(strconv.Itoa(int(tes.State_PAUSED))),
+				},
+			}
+
 		case tes.State_EXECUTOR_ERROR:
-			item.ConditionExpression = aws.String("#state IN (:i, :r)")
+			item.ConditionExpression = aws.String("#state IN (:i, :r, :p)")
 			item.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
 				":to": {
 					N: aws.String(strconv.Itoa(int(tes.State_EXECUTOR_ERROR))),
@@ -138,10 +166,13 @@ func (db *DynamoDB) WriteContext(ctx context.Context, e *events.Event) error {
 				":r": {
 					N: aws.String(strconv.Itoa(int(tes.State_RUNNING))),
 				},
+				":p": {
+					N: aws.String(strconv.Itoa(int(tes.State_PAUSED))),
+				},
 			}
 
 		case tes.State_SYSTEM_ERROR, tes.State_CANCELED:
-			item.ConditionExpression = aws.String("#state IN (:q, :i, :r)")
+			item.ConditionExpression = aws.String("#state IN (:q, :i, :r, :p)")
 			item.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
 				":to": {
 					N: aws.String(strconv.Itoa(int(e.GetState()))),
@@ -155,6 +186,9 @@ func (db *DynamoDB) WriteContext(ctx context.Context, e *events.Event) error {
 				":r": {
 					N: aws.String(strconv.Itoa(int(tes.State_RUNNING))),
 				},
+				":p": {
+					N: aws.String(strconv.Itoa(int(tes.State_PAUSED))),
+				},
 			}
 		}
 
@@ -186,17 +220,52 @@ func (db *DynamoDB) WriteContext(ctx context.Context, e *events.Event) error {
 			},
 		}
 
+		// The output checksums are merged into the metadata,
+		// in the same update as the outputs.
+		if k, v, ok := events.OutputChecksumsMetadata(e.GetOutputs()); ok {
+			err := db.mergeMetadata(ctx, item, e.Attempt, map[string]string{k: v})
+			if err != nil {
+				return err
+			}
+			item.UpdateExpression = aws.String(fmt.Sprintf("%s, logs[%v].outputs = :o", *item.UpdateExpression, e.Attempt))
+			item.ExpressionAttributeValues[":o"] = &dynamodb.AttributeValue{L: val}
+		}
+
 	case events.Type_TASK_METADATA:
-		val, err := dynamodbattribute.MarshalMap(e.GetMetadata().Value)
+		m := e.GetMetadata().Value
+		if len(m) == 0 {
+			return nil
+		}
+		err := db.mergeMetadata(ctx, item, e.Attempt, m)
 		if err != nil {
-			return fmt.Errorf("failed to DynamoDB marshal TaskLog Metadata, %v", err)
+			return err
 		}
 
-		item.UpdateExpression = aws.String(fmt.Sprintf("SET logs[%v].metadata = :c", e.Attempt))
+	case events.Type_EXECUTOR_RESOURCE_USAGE:
+		k, v := events.ResourceUsageMetadata(e.Index, e.GetResourceUsage())
+		err := db.mergeMetadata(ctx, item, e.Attempt, map[string]string{k: v})
+		if err != nil {
+			return err
+		}
+
+	case events.Type_SYSTEM_LOG:
+		item.UpdateExpression = aws.String(fmt.Sprintf("SET logs[%v].system_logs = list_append(if_not_exists(logs[%v].system_logs, :e), :c)", e.Attempt, e.Attempt))
 		item.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
 			":c": {
-				M: val,
+				L: []*dynamodb.AttributeValue{
+					{S: aws.String(e.SysLogString())},
+				},
 			},
+			":e": {
+				L: []*dynamodb.AttributeValue{},
+			},
+		}
+
+	case events.Type_TASK_HEARTBEAT:
+		k, v := events.HeartbeatMetadata(e)
+		err := db.mergeMetadata(ctx, item, e.Attempt, map[string]string{k: v})
+		if err != nil {
+			return err
 		}
 
 	case events.Type_EXECUTOR_START_TIME:
@@ -285,6 +354,107 @@ func (db *DynamoDB) WriteContext(ctx context.Context, e *events.Event) error {
 	return err
 }
 
+// requeue puts a task back in the queue to retry it, starting the given
+// attempt. The task is submitted to the compute backend again. Without
+// a compute backend, e.g. when a worker writes events directly to the
+// database, the task is only put back in the queue, where Funnel's
+// scheduler finds it.
+func (db *DynamoDB) requeue(ctx context.Context, id string, attempt uint32) error {
+	task, err := db.GetTask(ctx, &tes.GetTaskRequest{
+		Id:   id,
+		View: tes.TaskView_BASIC,
+	})
+	if err != nil {
+		return err
+	}
+	if err := tes.ValidateRequeue(task, attempt); err != nil {
+		return err
+	}
+
+	// The condition guards against concurrent state changes and requeues.
+	item := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.taskTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			db.partitionKey: {
+				S: aws.String(db.partitionValue),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#state": aws.String("state"),
+		},
+		ConditionExpression: aws.String("#state = :from AND size(logs) = :n"),
+		UpdateExpression:    aws.String(fmt.Sprintf("SET #state = :to, logs[%v] = :v", attempt)),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":to": {
+				N: aws.String(strconv.Itoa(int(tes.State_QUEUED))),
+			},
+			":from": {
+				N: aws.String(strconv.Itoa(int(task.State))),
+			},
+			":n": {
+				N: aws.String(strconv.Itoa(len(task.Logs))),
+			},
+			":v": {
+				M: map[string]*dynamodb.AttributeValue{},
+			},
+		},
+	}
+	_, err = db.client.UpdateItemWithContext(ctx, item)
+	if err != nil {
+		return err
+	}
+
+	if db.backend == nil {
+		return nil
+	}
+
+	task.State = tes.State_QUEUED
+	task.Logs = append(task.Logs, &tes.TaskLog{})
+	err = db.backend.Submit(task)
+	if err != nil {
+		db.WriteContext(ctx, events.NewState(id, attempt, tes.State_SYSTEM_ERROR))
+		return fmt.Errorf("couldn't resubmit to compute backend: %s", err)
+	}
+	return nil
+}
+
+// mergeMetadata configures "item" to set the given keys in the metadata
+// of a task log, keeping the existing keys.
+func (db *DynamoDB) mergeMetadata(ctx context.Context, item *dynamodb.UpdateItemInput, attempt uint32, m map[string]string) error {
+	// create the metadata map for the attempt if it doesn't already exist
+	metaItem := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(db.taskTable),
+		Key:              item.Key,
+		UpdateExpression: aws.String(fmt.Sprintf("SET logs[%v].metadata = if_not_exists(logs[%v].metadata, :v)", attempt, attempt)),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v": {
+				M: map[string]*dynamodb.AttributeValue{},
+			},
+		},
+	}
+	_, err := db.client.UpdateItemWithContext(ctx, metaItem)
+	if err != nil {
+		return err
+	}
+
+	var sets []string
+	item.ExpressionAttributeNames = map[string]*string{}
+	item.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{}
+	for k, v := range m {
+		i := len(sets)
+		sets = append(sets, fmt.Sprintf("logs[%v].metadata.#k%d = :c%d", attempt, i, i))
+		item.ExpressionAttributeNames[fmt.Sprintf("#k%d", i)] = aws.String(k)
+		item.ExpressionAttributeValues[fmt.Sprintf(":c%d", i)] = &dynamodb.AttributeValue{
+			S: aws.String(v),
+		}
+	}
+	item.UpdateExpression = aws.String("SET " + strings.Join(sets, ", "))
+	return nil
+}
+
 // Close closes the writer.
 func (db *DynamoDB) Close() error {
 	return nil
@@ -3,8 +3,10 @@ package dynamodb
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -69,6 +71,10 @@ func (db *DynamoDB) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.
 		return nil, fmt.Errorf("failed to DynamoDB unmarshal Task, %v", err)
 	}
 
+	if req.View == tes.TaskView_BASIC {
+		removeSystemLogs(task)
+	}
+
 	return task, nil
 }
 
@@ -140,6 +146,12 @@ func (db *DynamoDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*
 		return nil, fmt.Errorf("failed to DynamoDB unmarshal Tasks, %v", err)
 	}
 
+	if req.View == tes.TaskView_BASIC {
+		for _, task := range tasks {
+			removeSystemLogs(task)
+		}
+	}
+
 	out := tes.ListTasksResponse{
 		Tasks: tasks,
 	}
@@ -197,6 +209,77 @@ func (db *DynamoDB) CancelTask(ctx context.Context, req *tes.CancelTaskRequest)
 	return &tes.CancelTaskResponse{}, nil
 }
 
+// PauseTask pauses a running task. The task's worker freezes the running
+// executor when it sees the PAUSED state.
+func (db *DynamoDB) PauseTask(ctx context.Context, req *control.PauseTaskRequest) (*control.PauseTaskResponse, error) {
+	t, err := db.GetTask(ctx, &tes.GetTaskRequest{Id: req.Id, View: tes.TaskView_MINIMAL})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.updateState(ctx, req.Id, t.GetState(), tes.State_PAUSED)
+	if err != nil {
+		return nil, err
+	}
+	return &control.PauseTaskResponse{}, nil
+}
+
+// ResumeTask resumes a paused task, moving it back to the RUNNING state.
+func (db *DynamoDB) ResumeTask(ctx context.Context, req *control.ResumeTaskRequest) (*control.ResumeTaskResponse, error) {
+	t, err := db.GetTask(ctx, &tes.GetTaskRequest{Id: req.Id, View: tes.TaskView_MINIMAL})
+	if err != nil {
+		return nil, err
+	}
+	if t.GetState() != tes.State_PAUSED {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "task %s isn't paused, its state is %s", req.Id, t.GetState())
+	}
+
+	err = db.updateState(ctx, req.Id, t.GetState(), tes.State_RUNNING)
+	if err != nil {
+		return nil, err
+	}
+	return &control.ResumeTaskResponse{}, nil
+}
+
+// updateState validates and writes a task state transition. The condition
+// guards against concurrent state changes, e.g. the worker completing the task.
+func (db *DynamoDB) updateState(ctx context.Context, id string, from, to tes.State) error {
+	if err := tes.ValidateTransition(from, to); err != nil {
+		return grpc.Errorf(codes.FailedPrecondition, err.Error())
+	}
+
+	item := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.taskTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			db.partitionKey: {
+				S: aws.String(db.partitionValue),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#state": aws.String("state"),
+		},
+		ConditionExpression: aws.String("#state = :from"),
+		UpdateExpression:    aws.String("SET #state = :to"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":to": {
+				N: aws.String(strconv.Itoa(int(to))),
+			},
+			":from": {
+				N: aws.String(strconv.Itoa(int(from))),
+			},
+		},
+	}
+
+	_, err := db.client.UpdateItemWithContext(ctx, item)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return grpc.Errorf(codes.FailedPrecondition, "task %s changed state, try again", id)
+	}
+	return err
+}
+
 // GetServiceInfo provides an endpoint for Funnel clients to get information about this server.
 func (db *DynamoDB) GetServiceInfo(ctx context.Context, info *tes.ServiceInfoRequest) (*tes.ServiceInfo, error) {
 	return &tes.ServiceInfo{}, nil
@@ -461,3 +461,11 @@ func (db *DynamoDB) getExecutorOutput(ctx context.Context, in map[string]*dynamo
 	}
 	return nil
 }
+
+// removeSystemLogs removes the system logs from the task logs,
+// which aren't included in the basic view.
+func removeSystemLogs(task *tes.Task) {
+	for _, tl := range task.Logs {
+		tl.SystemLogs = nil
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/ohsu-comp-bio/funnel/compute"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
@@ -20,6 +21,7 @@ type Elastic struct {
 	conf      config.Elastic
 	taskIndex string
 	nodeIndex string
+	backend   compute.Backend
 }
 
 // NewElastic returns a new Elastic instance.
@@ -41,6 +43,7 @@ func NewElastic(conf config.Elastic) (*Elastic, error) {
 		conf,
 		conf.IndexPrefix + "-tasks",
 		conf.IndexPrefix + "-nodes",
+		nil,
 	}, nil
 }
 
@@ -164,7 +167,7 @@ func (es *Elastic) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*t
 
 var minimal = elastic.NewFetchSourceContext(true).Include("id", "state")
 var basic = elastic.NewFetchSourceContext(true).
-	Exclude("logs.logs.stderr", "logs.logs.stdout", "inputs.content")
+	Exclude("logs.logs.stderr", "logs.logs.stdout", "logs.system_logs", "inputs.content")
 
 // GetTask gets a task by ID.
 func (es *Elastic) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.Task, error) {
@@ -214,6 +217,85 @@ for (; params.attempt > ctx._source.logs.length - 1; ) {
 ctx._source.logs[params.attempt][params.field] = params.value;
 `
 
+var updateTaskLogMetadata = `
+if (ctx._source.logs == null) {
+  ctx._source.logs = new ArrayList();
+}
+
+// Ensure the task logs array is long enough.
+for (; params.attempt > ctx._source.logs.length - 1; ) {
+  Map m = new HashMap();
+  m.logs = new ArrayList();
+  ctx._source.logs.add(m);
+}
+
+// Ensure the metadata map exists.
+if (ctx._source.logs[params.attempt].metadata == null) {
+  ctx._source.logs[params.attempt].metadata = new HashMap();
+}
+
+// Merge the metadata keys.
+ctx._source.logs[params.attempt].metadata.putAll(params.metadata);
+`
+
+var updateTaskLogOutputs = `
+if (ctx._source.logs == null) {
+  ctx._source.logs = new ArrayList();
+}
+
+// Ensure the task logs array is long enough.
+for (; params.attempt > ctx._source.logs.length - 1; ) {
+  Map m = new HashMap();
+  m.logs = new ArrayList();
+  ctx._source.logs.add(m);
+}
+
+ctx._source.logs[params.attempt].outputs = params.outputs;
+
+// Merge the metadata keys, e.g. the output checksums.
+if (!params.metadata.isEmpty()) {
+  if (ctx._source.logs[params.attempt].metadata == null) {
+    ctx._source.logs[params.attempt].metadata = new HashMap();
+  }
+  ctx._source.logs[params.attempt].metadata.putAll(params.metadata);
+}
+`
+
+var updateTaskLogSystemLogs = `
+if (ctx._source.logs == null) {
+  ctx._source.logs = new ArrayList();
+}
+
+// Ensure the task logs array is long enough.
+for (; params.attempt > ctx._source.logs.length - 1; ) {
+  Map m = new HashMap();
+  m.logs = new ArrayList();
+  ctx._source.logs.add(m);
+}
+
+// Ensure the system logs array exists.
+if (ctx._source.logs[params.attempt].system_logs == null) {
+  ctx._source.logs[params.attempt].system_logs = new ArrayList();
+}
+
+ctx._source.logs[params.attempt].system_logs.add(params.syslog);
+`
+
+var requeueTask = `
+if (ctx._source.logs == null) {
+  ctx._source.logs = new ArrayList();
+}
+
+// Ensure the task logs array is long enough.
+for (; params.attempt > ctx._source.logs.length - 1; ) {
+  Map m = new HashMap();
+  m.logs = new ArrayList();
+  ctx._source.logs.add(m);
+}
+
+ctx._source.state = params.state;
+`
+
 var updateExecutorLogs = `
 if (ctx._source.logs == null) {
   ctx._source.logs = new ArrayList();
@@ -244,6 +326,81 @@ func taskLogUpdate(attempt uint32, field string, value interface{}) *elastic.Scr
 		Param("value", value)
 }
 
+func taskLogMetadataUpdate(attempt uint32, metadata map[string]string) *elastic.Script {
+	return elastic.NewScript(updateTaskLogMetadata).
+		Lang("painless").
+		Param("attempt", attempt).
+		Param("metadata", metadata)
+}
+
+func taskLogOutputsUpdate(attempt uint32, outputs []*tes.OutputFileLog, metadata map[string]string) *elastic.Script {
+	return elastic.NewScript(updateTaskLogOutputs).
+		Lang("painless").
+		Param("attempt", attempt).
+		Param("outputs", outputs).
+		Param("metadata", metadata)
+}
+
+func taskLogSystemLogsUpdate(attempt uint32, syslog string) *elastic.Script {
+	return elastic.NewScript(updateTaskLogSystemLogs).
+		Lang("painless").
+		Param("attempt", attempt).
+		Param("syslog", syslog)
+}
+
+// requeue puts a task back in the queue to retry it, starting the given
+// attempt. The task is submitted to the compute backend again. Without
+// a compute backend, e.g. when a worker writes events directly to the
+// database, the task is only put back in the queue, where Funnel's
+// scheduler finds it.
+func (es *Elastic) requeue(ctx context.Context, id string, attempt uint32) error {
+	task, err := es.GetTask(ctx, &tes.GetTaskRequest{
+		Id:   id,
+		View: tes.TaskView_BASIC,
+	})
+	if err != nil {
+		return fmt.Errorf("error fetch current state: %v", err)
+	}
+	if err := tes.ValidateRequeue(task, attempt); err != nil {
+		return err
+	}
+
+	script := elastic.NewScript(requeueTask).
+		Lang("painless").
+		Param("attempt", attempt).
+		Param("state", tes.State_QUEUED.String())
+
+	_, err = es.client.Update().
+		Index(es.taskIndex).
+		Type("task").
+		RetryOnConflict(3).
+		Id(id).
+		Script(script).
+		Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	if es.backend == nil {
+		return nil
+	}
+
+	task.State = tes.State_QUEUED
+	task.Logs = append(task.Logs, &tes.TaskLog{})
+	err = es.backend.Submit(task)
+	if err != nil {
+		es.client.Update().
+			Index(es.taskIndex).
+			Type("task").
+			RetryOnConflict(3).
+			Id(id).
+			Doc(map[string]string{"state": tes.State_SYSTEM_ERROR.String()}).
+			Do(ctx)
+		return fmt.Errorf("couldn't resubmit to compute backend: %v", err)
+	}
+	return nil
+}
+
 func execLogUpdate(attempt, index uint32, field string, value interface{}) *elastic.Script {
 	return elastic.NewScript(updateExecutorLogs).
 		Lang("painless").
@@ -255,9 +412,9 @@ func execLogUpdate(attempt, index uint32, field string, value interface{}) *elas
 
 // WriteContext writes a task update event.
 func (es *Elastic) WriteContext(ctx context.Context, ev *events.Event) error {
-	// Skipping system logs for now. Will add them to the task logs when this PR is resolved (soon):
-	// https://github.com/ga4gh/task-execution-schemas/pull/80
-	if ev.Type == events.Type_SYSTEM_LOG {
+	// System logs are skipped, except the one explaining that the task's
+	// worker is lost.
+	if ev.Type == events.Type_SYSTEM_LOG && !events.IsWorkerLost(ev) {
 		return nil
 	}
 
@@ -281,6 +438,9 @@ func (es *Elastic) WriteContext(ctx context.Context, ev *events.Event) error {
 		}
 		from := res.State
 		to := ev.GetState()
+		if to == tes.State_QUEUED {
+			return es.requeue(ctx, ev.Id, ev.Attempt)
+		}
 		if err := tes.ValidateTransition(from, to); err != nil {
 			return err
 		}
@@ -293,10 +453,25 @@ func (es *Elastic) WriteContext(ctx context.Context, ev *events.Event) error {
 		u = u.Script(taskLogUpdate(ev.Attempt, "end_time", ev.GetEndTime()))
 
 	case events.Type_TASK_OUTPUTS:
-		u = u.Script(taskLogUpdate(ev.Attempt, "outputs", ev.GetOutputs().Value))
+		meta := map[string]string{}
+		if k, v, ok := events.OutputChecksumsMetadata(ev.GetOutputs()); ok {
+			meta[k] = v
+		}
+		u = u.Script(taskLogOutputsUpdate(ev.Attempt, ev.GetOutputs().Value, meta))
 
 	case events.Type_TASK_METADATA:
-		u = u.Script(taskLogUpdate(ev.Attempt, "metadata", ev.GetMetadata().Value))
+		u = u.Script(taskLogMetadataUpdate(ev.Attempt, ev.GetMetadata().Value))
+
+	case events.Type_EXECUTOR_RESOURCE_USAGE:
+		k, v := events.ResourceUsageMetadata(ev.Index, ev.GetResourceUsage())
+		u = u.Script(taskLogMetadataUpdate(ev.Attempt, map[string]string{k: v}))
+
+	case events.Type_SYSTEM_LOG:
+		u = u.Script(taskLogSystemLogsUpdate(ev.Attempt, ev.SysLogString()))
+
+	case events.Type_TASK_HEARTBEAT:
+		k, v := events.HeartbeatMetadata(ev)
+		u = u.Script(taskLogMetadataUpdate(ev.Attempt, map[string]string{k: v}))
 
 	case events.Type_EXECUTOR_START_TIME:
 		u = u.Script(execLogUpdate(ev.Attempt, ev.Index, "start_time", ev.GetStartTime()))
@@ -5,6 +5,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/compute"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -27,6 +28,7 @@ func NewTES(conf config.Elastic) (*TES, error) {
 // WithComputeBackend sets the compute backend.
 func (et *TES) WithComputeBackend(b compute.Backend) {
 	et.Backend = b
+	et.Elastic.backend = b
 }
 
 // CreateTask creates a new task.
@@ -77,6 +79,41 @@ func (et *TES) CancelTask(ctx context.Context, req *tes.CancelTaskRequest) (*tes
 	return &tes.CancelTaskResponse{}, err
 }
 
+// PauseTask pauses a running task. The task's worker freezes the running
+// executor when it sees the PAUSED state.
+func (et *TES) PauseTask(ctx context.Context, req *control.PauseTaskRequest) (*control.PauseTaskResponse, error) {
+	task, err := et.GetTask(ctx, &tes.GetTaskRequest{
+		Id:   req.Id,
+		View: tes.TaskView_MINIMAL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tes.ValidateTransition(task.State, tes.State_PAUSED); err != nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, err.Error())
+	}
+
+	err = et.Elastic.WriteContext(ctx, events.NewState(req.Id, 0, tes.State_PAUSED))
+	return &control.PauseTaskResponse{}, err
+}
+
+// ResumeTask resumes a paused task, moving it back to the RUNNING state.
+func (et *TES) ResumeTask(ctx context.Context, req *control.ResumeTaskRequest) (*control.ResumeTaskResponse, error) {
+	task, err := et.GetTask(ctx, &tes.GetTaskRequest{
+		Id:   req.Id,
+		View: tes.TaskView_MINIMAL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if task.State != tes.State_PAUSED {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "task %s isn't paused, its state is %s", req.Id, task.State)
+	}
+
+	err = et.Elastic.WriteContext(ctx, events.NewState(req.Id, 0, tes.State_RUNNING))
+	return &control.ResumeTaskResponse{}, err
+}
+
 // GetServiceInfo returns service metadata.
 func (et *TES) GetServiceInfo(ctx context.Context, info *tes.ServiceInfoRequest) (*tes.ServiceInfo, error) {
 	return &tes.ServiceInfo{Name: "elastic"}, nil
@@ -88,5 +125,5 @@ func (et *TES) CreateEvent(ctx context.Context, req *events.Event) (*events.Crea
 	if err != nil {
 		return nil, err
 	}
-	return &events.CreateEventResponse{}, err
+	return &events.CreateEventResponse{}, nil
 }
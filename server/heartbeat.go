@@ -0,0 +1,125 @@
+package server
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"golang.org/x/net/context"
+	"strconv"
+	"time"
+)
+
+// HeartbeatMonitor checks the heartbeats sent by the workers of running tasks.
+// A task whose worker stopped sending heartbeats, e.g. because the worker's
+// node was lost, is failed with SYSTEM_ERROR, or retried according to the
+// retry policy.
+//
+// This is mainly useful for backends without Funnel's scheduler, e.g. HPC
+// backends, where nothing else notices that a worker is gone.
+type HeartbeatMonitor struct {
+	Log   *logger.Logger
+	DB    Database
+	Conf  config.Server
+	Retry config.RetryPolicy
+}
+
+// Run checks the task heartbeats every Conf.HeartbeatCheckRate,
+// until the context is canceled. This blocks.
+func (m *HeartbeatMonitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.Conf.HeartbeatCheckRate)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := m.CheckTasks(ctx)
+			if err != nil {
+				m.Log.Error("Couldn't check task heartbeats", "error", err)
+			}
+		}
+	}
+}
+
+// CheckTasks fails or retries the running tasks which haven't sent
+// a heartbeat within Conf.HeartbeatTimeout.
+func (m *HeartbeatMonitor) CheckTasks(ctx context.Context) error {
+	now := time.Now()
+	pageToken := ""
+
+	for {
+		// The basic view includes the task logs, where the last heartbeat
+		// is stored, so the tasks are checked in a single pass.
+		// ListTasksRequest can't filter by state, so that's done here.
+		resp, err := m.DB.ListTasks(ctx, &tes.ListTasksRequest{
+			View:      tes.TaskView_BASIC,
+			PageToken: pageToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, task := range resp.Tasks {
+			if !tes.RunnableState(task.State) && task.State != tes.State_PAUSED {
+				continue
+			}
+
+			if m.lost(task, now) {
+				err = m.fail(ctx, task)
+				if err != nil {
+					m.Log.Error("Couldn't update lost task", "taskID", task.Id, "error", err)
+				}
+			}
+		}
+
+		if resp.NextPageToken == "" {
+			return nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// lost returns true if the task's last heartbeat is older than the timeout.
+// Tasks without heartbeats, e.g. because the worker has heartbeats disabled,
+// are never lost.
+func (m *HeartbeatMonitor) lost(task *tes.Task, now time.Time) bool {
+	last, ok := events.LastHeartbeat(task)
+	return ok && now.Sub(last) > m.Conf.HeartbeatTimeout
+}
+
+// retries returns true if the lost task is retried, instead of failed.
+func (m *HeartbeatMonitor) retries(task *tes.Task) bool {
+	// Only INITIALIZING or RUNNING tasks can be requeued.
+	return tes.RunnableState(task.State) &&
+		m.Retry.Retries(tes.State_SYSTEM_ERROR.String()) &&
+		task.Attempt()+1 < m.Retry.MaxAttempts
+}
+
+// fail logs why the task is lost to its system logs, and then requeues it
+// for the next attempt or sets its state to SYSTEM_ERROR.
+func (m *HeartbeatMonitor) fail(ctx context.Context, task *tes.Task) error {
+	attempt := task.Attempt()
+	last, _ := events.LastHeartbeat(task)
+	retry := m.retries(task)
+
+	m.Log.Info("Task worker lost", "taskID", task.Id, "lastHeartbeat", last, "retry", retry)
+
+	msg := events.NewWorkerLost(task.Id, attempt, map[string]string{
+		"lastHeartbeat":    last.Format(time.RFC3339Nano),
+		"heartbeatTimeout": m.Conf.HeartbeatTimeout.String(),
+		"retry":            strconv.FormatBool(retry),
+	})
+	_, err := m.DB.CreateEvent(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	ev := events.NewState(task.Id, attempt, tes.State_SYSTEM_ERROR)
+	if retry {
+		ev = events.NewState(task.Id, attempt+1, tes.State_QUEUED)
+	}
+	_, err = m.DB.CreateEvent(ctx, ev)
+	return err
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"testing"
+	"time"
+)
+
+func heartbeatTask(state tes.State, attempts int, last string) *tes.Task {
+	task := &tes.Task{State: state}
+	for i := 0; i < attempts; i++ {
+		task.Logs = append(task.Logs, &tes.TaskLog{})
+	}
+	if last != "" {
+		task.Logs[attempts-1].Metadata = map[string]string{
+			"worker_heartbeat": last,
+		}
+	}
+	return task
+}
+
+func TestHeartbeatMonitorLost(t *testing.T) {
+	now := time.Now()
+	m := &HeartbeatMonitor{}
+	m.Conf.HeartbeatTimeout = time.Minute
+
+	recent := now.Add(-time.Second).Format(time.RFC3339Nano)
+	old := now.Add(-time.Hour).Format(time.RFC3339Nano)
+
+	if m.lost(heartbeatTask(tes.State_RUNNING, 1, recent), now) {
+		t.Error("expected task with a recent heartbeat not to be lost")
+	}
+	if !m.lost(heartbeatTask(tes.State_RUNNING, 1, old), now) {
+		t.Error("expected task with an old heartbeat to be lost")
+	}
+	if m.lost(heartbeatTask(tes.State_RUNNING, 1, ""), now) {
+		t.Error("expected task without heartbeats not to be lost")
+	}
+	// Only the heartbeats of the current attempt count.
+	task := heartbeatTask(tes.State_RUNNING, 2, "")
+	task.Logs[0].Metadata = map[string]string{"worker_heartbeat": old}
+	if m.lost(task, now) {
+		t.Error("expected heartbeats of a previous attempt to be ignored")
+	}
+}
+
+func TestHeartbeatMonitorRetries(t *testing.T) {
+	m := &HeartbeatMonitor{
+		Retry: config.RetryPolicy{
+			MaxAttempts: 2,
+			States:      []string{"SYSTEM_ERROR"},
+		},
+	}
+
+	if !m.retries(heartbeatTask(tes.State_RUNNING, 1, "")) {
+		t.Error("expected first attempt to be retried")
+	}
+	if m.retries(heartbeatTask(tes.State_RUNNING, 2, "")) {
+		t.Error("expected last attempt not to be retried")
+	}
+	if m.retries(heartbeatTask(tes.State_PAUSED, 1, "")) {
+		t.Error("expected paused task not to be retried")
+	}
+
+	m.Retry.States = []string{"EXECUTOR_ERROR"}
+	if m.retries(heartbeatTask(tes.State_RUNNING, 1, "")) {
+		t.Error("expected task not to be retried when SYSTEM_ERROR isn't retried")
+	}
+}
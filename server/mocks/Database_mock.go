@@ -2,6 +2,7 @@ package mocks
 
 import compute "github.com/ohsu-comp-bio/funnel/compute"
 import context "golang.org/x/net/context"
+import control "github.com/ohsu-comp-bio/funnel/proto/control"
 import events "github.com/ohsu-comp-bio/funnel/events"
 import mock "github.com/stretchr/testify/mock"
 import scheduler "github.com/ohsu-comp-bio/funnel/proto/scheduler"
@@ -211,6 +212,29 @@ func (_m *Database) ListTasks(_a0 context.Context, _a1 *tes.ListTasksRequest) (*
 	return r0, r1
 }
 
+// PauseTask provides a mock function with given fields: _a0, _a1
+func (_m *Database) PauseTask(_a0 context.Context, _a1 *control.PauseTaskRequest) (*control.PauseTaskResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *control.PauseTaskResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *control.PauseTaskRequest) *control.PauseTaskResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*control.PauseTaskResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *control.PauseTaskRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // PutNode provides a mock function with given fields: _a0, _a1
 func (_m *Database) PutNode(_a0 context.Context, _a1 *scheduler.Node) (*scheduler.PutNodeResponse, error) {
 	ret := _m.Called(_a0, _a1)
@@ -234,6 +258,29 @@ func (_m *Database) PutNode(_a0 context.Context, _a1 *scheduler.Node) (*schedule
 	return r0, r1
 }
 
+// ResumeTask provides a mock function with given fields: _a0, _a1
+func (_m *Database) ResumeTask(_a0 context.Context, _a1 *control.ResumeTaskRequest) (*control.ResumeTaskResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *control.ResumeTaskResponse
+	if rf, ok := ret.Get(0).(func(context.Context, *control.ResumeTaskRequest) *control.ResumeTaskResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*control.ResumeTaskResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *control.ResumeTaskRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // WithComputeBackend provides a mock function with given fields: _a0
 func (_m *Database) WithComputeBackend(_a0 compute.Backend) {
 	_m.Called(_a0)
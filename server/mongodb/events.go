@@ -15,17 +15,25 @@ func (db *MongoDB) CreateEvent(ctx context.Context, req *events.Event) (*events.
 }
 
 // Write writes task events to the database, updating the task record they
-// are related to. System log events are ignored.
+// are related to. System log events are ignored, except the one explaining
+// that the task's worker is lost.
 func (db *MongoDB) Write(req *events.Event) error {
 	return db.WriteContext(context.Background(), req)
 }
 
 // WriteContext is Write, but with context.
 func (db *MongoDB) WriteContext(ctx context.Context, req *events.Event) error {
+	if req.Type == events.Type_SYSTEM_LOG && !events.IsWorkerLost(req) {
+		return nil
+	}
+
 	var err error
 
 	switch req.Type {
 	case events.Type_TASK_STATE:
+		if req.GetState() == tes.State_QUEUED {
+			return db.requeue(ctx, req.Id, req.Attempt)
+		}
 		res, err := db.GetTask(ctx, &tes.GetTaskRequest{
 			Id:   req.Id,
 			View: tes.TaskView_MINIMAL,
@@ -56,16 +64,48 @@ func (db *MongoDB) WriteContext(ctx context.Context, req *events.Event) error {
 
 	case events.Type_TASK_OUTPUTS:
 		outputs := req.GetOutputs().Value
+		fields := bson.M{fmt.Sprintf("logs.%v.outputs", req.Attempt): outputs}
+		if k, v, ok := events.OutputChecksumsMetadata(req.GetOutputs()); ok {
+			fields[fmt.Sprintf("logs.%v.metadata.%s", req.Attempt, k)] = v
+		}
 		err = db.tasks.Update(
 			bson.M{"id": req.Id},
-			bson.M{"$set": bson.M{fmt.Sprintf("logs.%v.outputs", req.Attempt): outputs}},
+			bson.M{"$set": fields},
 		)
 
 	case events.Type_TASK_METADATA:
-		metadata := req.GetMetadata().Value
+		// Metadata keys are merged into the existing metadata.
+		fields := bson.M{}
+		for k, v := range req.GetMetadata().Value {
+			fields[fmt.Sprintf("logs.%v.metadata.%s", req.Attempt, k)] = v
+		}
+		if len(fields) > 0 {
+			err = db.tasks.Update(
+				bson.M{"id": req.Id},
+				bson.M{"$set": fields},
+			)
+		}
+
+	case events.Type_EXECUTOR_RESOURCE_USAGE:
+		k, v := events.ResourceUsageMetadata(req.Index, req.GetResourceUsage())
+		field := fmt.Sprintf("logs.%v.metadata.%s", req.Attempt, k)
 		err = db.tasks.Update(
 			bson.M{"id": req.Id},
-			bson.M{"$set": bson.M{fmt.Sprintf("logs.%v.metadata", req.Attempt): metadata}},
+			bson.M{"$set": bson.M{field: v}},
+		)
+
+	case events.Type_SYSTEM_LOG:
+		err = db.tasks.Update(
+			bson.M{"id": req.Id},
+			bson.M{"$push": bson.M{fmt.Sprintf("logs.%v.systemlogs", req.Attempt): req.SysLogString()}},
+		)
+
+	case events.Type_TASK_HEARTBEAT:
+		k, v := events.HeartbeatMetadata(req)
+		field := fmt.Sprintf("logs.%v.metadata.%s", req.Attempt, k)
+		err = db.tasks.Update(
+			bson.M{"id": req.Id},
+			bson.M{"$set": bson.M{field: v}},
 		)
 
 	case events.Type_EXECUTOR_START_TIME:
@@ -106,3 +146,43 @@ func (db *MongoDB) WriteContext(ctx context.Context, req *events.Event) error {
 
 	return err
 }
+
+// requeue puts a task back in the queue to retry it, starting the given
+// attempt. The task is submitted to the compute backend again. Without
+// a compute backend, e.g. when a worker writes events directly to the
+// database, the task is only put back in the queue, where Funnel's
+// scheduler finds it.
+func (db *MongoDB) requeue(ctx context.Context, id string, attempt uint32) error {
+	task, err := db.GetTask(ctx, &tes.GetTaskRequest{
+		Id:   id,
+		View: tes.TaskView_BASIC,
+	})
+	if err != nil {
+		return fmt.Errorf("error fetch current state: %v", err)
+	}
+	if err := tes.ValidateRequeue(task, attempt); err != nil {
+		return err
+	}
+
+	tl := &tes.TaskLog{Logs: []*tes.ExecutorLog{}}
+	err = db.tasks.Update(
+		bson.M{"id": id, "state": task.State},
+		bson.M{"$set": bson.M{"state": tes.State_QUEUED}, "$push": bson.M{"logs": tl}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if db.backend == nil {
+		return nil
+	}
+
+	task.State = tes.State_QUEUED
+	task.Logs = append(task.Logs, tl)
+	err = db.backend.Submit(task)
+	if err != nil {
+		db.tasks.Update(bson.M{"id": id}, bson.M{"$set": bson.M{"state": tes.State_SYSTEM_ERROR}})
+		return fmt.Errorf("couldn't resubmit to compute backend: %v", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package mongodb
 
 import (
 	"fmt"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -10,7 +11,7 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
-var basicView = bson.M{"logs.logs.stdout": 0, "logs.logs.stderr": 0, "inputs.content": 0}
+var basicView = bson.M{"logs.logs.stdout": 0, "logs.logs.stderr": 0, "logs.systemlogs": 0, "inputs.content": 0}
 var minimalView = bson.M{"id": 1, "state": 1}
 
 // CreateTask provides an HTTP/gRPC endpoint for creating a task.
@@ -121,6 +122,58 @@ func (db *MongoDB) CancelTask(ctx context.Context, req *tes.CancelTaskRequest) (
 	return &tes.CancelTaskResponse{}, err
 }
 
+// PauseTask pauses a running task. The task's worker freezes the running
+// executor when it sees the PAUSED state.
+func (db *MongoDB) PauseTask(ctx context.Context, req *control.PauseTaskRequest) (*control.PauseTaskResponse, error) {
+	task, err := db.GetTask(ctx, &tes.GetTaskRequest{
+		Id:   req.Id,
+		View: tes.TaskView_MINIMAL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.updateState(req.Id, task.State, tes.State_PAUSED)
+	if err != nil {
+		return nil, err
+	}
+	return &control.PauseTaskResponse{}, nil
+}
+
+// ResumeTask resumes a paused task, moving it back to the RUNNING state.
+func (db *MongoDB) ResumeTask(ctx context.Context, req *control.ResumeTaskRequest) (*control.ResumeTaskResponse, error) {
+	task, err := db.GetTask(ctx, &tes.GetTaskRequest{
+		Id:   req.Id,
+		View: tes.TaskView_MINIMAL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if task.State != tes.State_PAUSED {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "task %s isn't paused, its state is %s", req.Id, task.State)
+	}
+
+	err = db.updateState(req.Id, task.State, tes.State_RUNNING)
+	if err != nil {
+		return nil, err
+	}
+	return &control.ResumeTaskResponse{}, nil
+}
+
+// updateState validates and writes a task state transition. The update
+// only matches the task if it is still in the "from" state, so that a
+// concurrent update, e.g. the worker completing the task, isn't overwritten.
+func (db *MongoDB) updateState(id string, from, to tes.State) error {
+	if err := tes.ValidateTransition(from, to); err != nil {
+		return grpc.Errorf(codes.FailedPrecondition, err.Error())
+	}
+	err := db.tasks.Update(bson.M{"id": id, "state": from}, bson.M{"$set": bson.M{"state": to}})
+	if err == mgo.ErrNotFound {
+		return grpc.Errorf(codes.FailedPrecondition, "task %s changed state, try again", id)
+	}
+	return err
+}
+
 // GetServiceInfo provides an endpoint for Funnel clients to get information about this server.
 func (db *MongoDB) GetServiceInfo(ctx context.Context, info *tes.ServiceInfoRequest) (*tes.ServiceInfo, error) {
 	return &tes.ServiceInfo{}, nil
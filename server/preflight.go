@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/storage"
+	"github.com/ohsu-comp-bio/funnel/util"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	urllib "net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// skipPreflightTag is a task tag which skips the input preflight for the task,
+// e.g. for inputs which are created by another task before this one runs.
+const skipPreflightTag = "funnel_skip_input_preflight"
+
+// preflightParallel is the maximum number of inputs checked at once.
+const preflightParallel = 10
+
+// InputPreflight checks that the inputs of a new task exist and are
+// accessible, so that a task with e.g. a typo in an input URL is rejected
+// by CreateTask, instead of failing after it has been scheduled.
+//
+// Only inputs under the URL prefixes in Allowed are checked, since the
+// checks are requests made by the server, with the server's credentials,
+// to URLs chosen by whoever creates the task.
+type InputPreflight struct {
+	Store   storage.Storage
+	Allowed []string
+	Timeout time.Duration
+}
+
+// NewInputPreflight returns an InputPreflight which checks inputs using
+// the given storage configuration, usually the workers' configuration.
+//
+// The HTTP storage credentials are never sent from the server,
+// so they are removed from the configuration.
+func NewInputPreflight(conf config.Server, sconf config.StorageConfig) (*InputPreflight, error) {
+	if len(conf.PreflightURLPrefixes) == 0 {
+		return nil, fmt.Errorf("Server.PreflightInputs requires Server.PreflightURLPrefixes")
+	}
+
+	sconf.HTTP.Username = ""
+	sconf.HTTP.Password = ""
+	sconf.HTTP.BearerToken = ""
+	sconf.HTTP.AuthHosts = nil
+
+	store, err := storage.Storage{}.WithConfig(sconf)
+	if err != nil {
+		return nil, err
+	}
+	return &InputPreflight{
+		Store:   store,
+		Allowed: conf.PreflightURLPrefixes,
+		Timeout: conf.PreflightTimeout,
+	}, nil
+}
+
+// Check checks the task's inputs and returns a ValidationError with an error
+// for each input which can't be downloaded, or nil if all the inputs are ok.
+func (p *InputPreflight) Check(ctx context.Context, task *tes.Task) tes.ValidationError {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	inputs := task.GetInputs()
+	errs := make([]error, len(inputs))
+	checked := make([]bool, len(inputs))
+	util.ParallelDo(ctx, len(inputs), preflightParallel, func(ctx context.Context, i int) error {
+		errs[i] = p.checkInput(ctx, i, inputs[i])
+		checked[i] = true
+		return nil
+	})
+
+	// The checks which weren't started before the timeout fail,
+	// instead of passing silently.
+	if err := ctx.Err(); err != nil {
+		for i, input := range inputs {
+			if !checked[i] && p.checks(input) {
+				errs[i] = fmt.Errorf("Task.Inputs[%d].Url: not checked: %s: %s", i, err, input.Url)
+			}
+		}
+	}
+
+	var verr tes.ValidationError
+	for _, err := range errs {
+		if err != nil {
+			verr = append(verr, err)
+		}
+	}
+	return verr
+}
+
+// checks returns true if the input is checked: it has a URL under one of
+// the allowed URL prefixes. Inputs without a URL are either content inputs,
+// which don't need to be downloaded, or invalid, which CreateTask reports.
+func (p *InputPreflight) checks(input *tes.Input) bool {
+	return input.Url != "" && urlAllowed(input.Url, p.Allowed)
+}
+
+func (p *InputPreflight) checkInput(ctx context.Context, i int, input *tes.Input) error {
+	if !p.checks(input) {
+		return nil
+	}
+
+	if !p.Store.Supports(input.Url, input.Path, input.Type) {
+		return fmt.Errorf("Task.Inputs[%d].Url: not supported by storage: %s", i, input.Url)
+	}
+
+	switch input.Type {
+	case tes.FileType_FILE:
+		_, err := p.Store.Stat(ctx, input.Url)
+		if err == storage.ErrNotFound {
+			return fmt.Errorf("Task.Inputs[%d].Url: file not found: %s", i, input.Url)
+		}
+		if err != nil {
+			return fmt.Errorf("Task.Inputs[%d].Url: can't access file %s: %s", i, input.Url, err)
+		}
+
+	case tes.FileType_DIRECTORY:
+		// An empty directory is a valid input. Object stores can't tell
+		// an empty prefix from a missing one, so a directory is only
+		// rejected if the backend reports that it doesn't exist.
+		_, err := p.Store.List(ctx, input.Url)
+		if err == storage.ErrNotFound {
+			return fmt.Errorf("Task.Inputs[%d].Url: directory not found: %s", i, input.Url)
+		}
+		if err != nil {
+			return fmt.Errorf("Task.Inputs[%d].Url: can't access directory %s: %s", i, input.Url, err)
+		}
+	}
+	return nil
+}
+
+// urlAllowed returns true if the URL is under one of the URL prefixes,
+// e.g. "s3://bucket/data/" allows "s3://bucket/data/in.txt".
+// The scheme and host must match exactly, and the paths are compared
+// after cleaning, so that e.g. "file:///data/../etc" isn't under "file:///data".
+// Local paths without a scheme are file:// URLs.
+func urlAllowed(rawurl string, prefixes []string) bool {
+	u, err := parsePreflightURL(rawurl)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range prefixes {
+		a, err := parsePreflightURL(prefix)
+		if err != nil {
+			continue
+		}
+		if u.Scheme != a.Scheme || !strings.EqualFold(u.Host, a.Host) {
+			continue
+		}
+		dir := strings.TrimSuffix(a.Path, "/")
+		if dir == "" || u.Path == dir || strings.HasPrefix(u.Path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePreflightURL(rawurl string) (*urllib.URL, error) {
+	u, err := urllib.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("URL with user info: %s", rawurl)
+	}
+	if u.Scheme == "" {
+		u.Scheme = "file"
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Path = path.Clean("/" + u.Path)
+	return u, nil
+}
+
+// skip returns true if the task skips the preflight with the skipPreflightTag.
+func (p *InputPreflight) skip(task *tes.Task) (bool, error) {
+	v, ok := task.GetTags()[skipPreflightTag]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s tag %q: %s", skipPreflightTag, v, err)
+	}
+	return b, nil
+}
+
+// Return a new interceptor function that rejects CreateTask requests
+// for tasks whose inputs fail the preflight.
+func newPreflightInterceptor(p *InputPreflight) grpc.UnaryServerInterceptor {
+
+	// Return a function that is the interceptor.
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		task, ok := req.(*tes.Task)
+		if !ok || info.FullMethod != "/tes.TaskService/CreateTask" {
+			return handler(ctx, req)
+		}
+
+		skip, err := p.skip(task)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, err.Error())
+		}
+		if !skip {
+			if errs := p.Check(ctx, task); errs != nil {
+				return nil, grpc.Errorf(codes.InvalidArgument, errs.Error())
+			}
+		}
+		return handler(ctx, req)
+	}
+}
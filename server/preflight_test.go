@@ -0,0 +1,168 @@
+package server
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func preflightStorage(t *testing.T, dir string) storage.Storage {
+	store, err := storage.Storage{}.WithConfig(config.StorageConfig{
+		Local: config.LocalStorage{AllowedDirs: []string{dir}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestInputPreflight(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	os.MkdirAll(path.Join(tmp, "dir"), os.ModePerm)
+	os.MkdirAll(path.Join(tmp, "empty"), os.ModePerm)
+	ioutil.WriteFile(path.Join(tmp, "in.txt"), []byte("foo"), os.ModePerm)
+	ioutil.WriteFile(path.Join(tmp, "dir", "in.txt"), []byte("foo"), os.ModePerm)
+
+	p := &InputPreflight{
+		Store:   preflightStorage(t, "/"),
+		Allowed: []string{"file://" + tmp, "unknown://bucket/"},
+	}
+
+	ok := &tes.Task{
+		Inputs: []*tes.Input{
+			{Url: "file://" + tmp + "/in.txt", Path: "/in.txt"},
+			{Url: "file://" + tmp + "/dir", Path: "/dir", Type: tes.FileType_DIRECTORY},
+			{Url: "file://" + tmp + "/empty", Path: "/empty", Type: tes.FileType_DIRECTORY},
+			{Content: "content", Path: "/content.txt"},
+			// Not under an allowed prefix, so not checked.
+			{Url: "file://" + tmp + "-other/typo.txt", Path: "/other.txt"},
+			{Url: "file://" + tmp + "/../typo.txt", Path: "/parent.txt"},
+			{Url: "unknown://other/in.txt", Path: "/other-unknown.txt"},
+		},
+	}
+	if errs := p.Check(ctx, ok); errs != nil {
+		t.Error("unexpected errors", errs)
+	}
+
+	bad := &tes.Task{
+		Inputs: []*tes.Input{
+			{Url: "file://" + tmp + "/in.txt", Path: "/in.txt"},
+			{Url: "file://" + tmp + "/typo.txt", Path: "/typo.txt"},
+			{Url: "file://" + tmp + "/missing", Path: "/missing", Type: tes.FileType_DIRECTORY},
+			{Url: "unknown://bucket/in.txt", Path: "/unknown.txt"},
+		},
+	}
+	errs := p.Check(ctx, bad)
+	if len(errs) != 3 {
+		t.Fatal("expected 3 errors", errs)
+	}
+	for i, e := range []string{
+		"Task.Inputs[1].Url: file not found",
+		"Task.Inputs[2].Url: directory not found",
+		"Task.Inputs[3].Url: not supported by storage",
+	} {
+		if !strings.HasPrefix(errs[i].Error(), e) {
+			t.Errorf("expected error %q, got %q", e, errs[i])
+		}
+	}
+
+	// Inputs which aren't checked before the timeout fail.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	errs = p.Check(canceled, ok)
+	if len(errs) != 3 {
+		t.Fatal("expected 3 errors", errs)
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "not checked: context canceled") {
+			t.Errorf("expected not checked error, got %q", err)
+		}
+	}
+}
+
+func TestPreflightInterceptor(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	intercept := newPreflightInterceptor(&InputPreflight{
+		Store:   preflightStorage(t, tmp),
+		Allowed: []string{tmp},
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/tes.TaskService/CreateTask"}
+	called := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return &tes.CreateTaskResponse{}, nil
+	}
+
+	task := &tes.Task{
+		Inputs: []*tes.Input{
+			{Url: "file://" + tmp + "/typo.txt", Path: "/in.txt"},
+		},
+	}
+	_, err = intercept(ctx, task, info, handler)
+	if s, _ := status.FromError(err); err == nil || s.Code() != codes.InvalidArgument {
+		t.Error("expected InvalidArgument error", err)
+	}
+	if called {
+		t.Error("expected CreateTask not to be called")
+	}
+
+	task.Tags = map[string]string{"funnel_skip_input_preflight": "true"}
+	_, err = intercept(ctx, task, info, handler)
+	if err != nil {
+		t.Error("unexpected error", err)
+	}
+	if !called {
+		t.Error("expected CreateTask to be called")
+	}
+}
+
+func TestPreflightURLAllowed(t *testing.T) {
+	prefixes := []string{"s3://bucket/data/", "https://data.example.com", "file:///data"}
+	for url, expected := range map[string]bool{
+		"s3://bucket/data/in.txt":              true,
+		"s3://bucket/data":                     true,
+		"s3://bucket/other/in.txt":             false,
+		"s3://bucket/data/../other/in.txt":     false,
+		"s3://other/data/in.txt":               false,
+		"gs://bucket/data/in.txt":              false,
+		"https://data.example.com/in.txt":      true,
+		"http://data.example.com/in.txt":       false,
+		"https://data.example.com.evil/in.txt": false,
+		"https://data.example.com@evil/in.txt": false,
+		"https://user@data.example.com/in.txt": false,
+		"file:///data/in.txt":                  true,
+		"/data/in.txt":                         true,
+		"/data-other/in.txt":                   false,
+		"/etc/passwd":                          false,
+	} {
+		if urlAllowed(url, prefixes) != expected {
+			t.Errorf("expected urlAllowed(%q) to be %v", url, expected)
+		}
+	}
+
+	_, err := NewInputPreflight(config.Server{}, config.StorageConfig{})
+	if err == nil {
+		t.Error("expected error without URL prefixes")
+	}
+}
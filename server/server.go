@@ -7,6 +7,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	pbs "github.com/ohsu-comp-bio/funnel/proto/scheduler"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"github.com/ohsu-comp-bio/funnel/webdash"
@@ -26,9 +27,12 @@ type Server struct {
 	TaskServiceServer      tes.TaskServiceServer
 	EventServiceServer     events.EventServiceServer
 	SchedulerServiceServer pbs.SchedulerServiceServer
+	ControlServiceServer   control.ControlServiceServer
 	DisableHTTPCache       bool
 	DialOptions            []grpc.DialOption
 	Log                    *logger.Logger
+	// Optional check of the inputs of new tasks, see InputPreflight.
+	InputPreflight *InputPreflight
 }
 
 // DefaultServer returns a new server instance.
@@ -40,6 +44,7 @@ func DefaultServer(db Database, conf config.Server) *Server {
 		TaskServiceServer:      db,
 		EventServiceServer:     db,
 		SchedulerServiceServer: db,
+		ControlServiceServer:   db,
 		DisableHTTPCache:       conf.DisableHTTPCache,
 		DialOptions: []grpc.DialOption{
 			grpc.WithInsecure(),
@@ -78,13 +83,18 @@ func (s *Server) Serve(pctx context.Context) error {
 		return err
 	}
 
+	interceptors := []grpc.UnaryServerInterceptor{
+		// API auth check.
+		newAuthInterceptor(s.Password),
+		newDebugInterceptor(s.Log),
+	}
+	if s.InputPreflight != nil {
+		interceptors = append(interceptors, newPreflightInterceptor(s.InputPreflight))
+	}
+
 	grpcServer := grpc.NewServer(
 		grpc.UnaryInterceptor(
-			grpc_middleware.ChainUnaryServer(
-				// API auth check.
-				newAuthInterceptor(s.Password),
-				newDebugInterceptor(s.Log),
-			),
+			grpc_middleware.ChainUnaryServer(interceptors...),
 		),
 	)
 
@@ -144,6 +154,17 @@ func (s *Server) Serve(pctx context.Context) error {
 		}
 	}
 
+	// Register Control service
+	if s.ControlServiceServer != nil {
+		control.RegisterControlServiceServer(grpcServer, s.ControlServiceServer)
+		err := control.RegisterControlServiceHandlerFromEndpoint(
+			ctx, grpcMux, s.RPCAddress, s.DialOptions,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
 	httpServer := &http.Server{
 		Addr:    ":" + s.HTTPPort,
 		Handler: mux,
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Checksum algorithms.
+const (
+	MD5    = "md5"
+	SHA256 = "sha256"
+)
+
+// ValidChecksum returns true if "algorithm" is a supported checksum algorithm.
+func ValidChecksum(algorithm string) bool {
+	return algorithm == MD5 || algorithm == SHA256
+}
+
+// Checksum returns the hex-encoded checksum of the file at the given "path",
+// using the given algorithm, either MD5 or SHA256.
+func Checksum(path string, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case MD5:
+		h = md5.New()
+	case SHA256:
+		h = sha256.New()
+	default:
+		return "", fmt.Errorf("unknown checksum algorithm: %s", algorithm)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// md5FromETag returns the ETag if it is the hex-encoded MD5 of the object,
+// which is true for objects uploaded in a single request to S3 or Swift.
+// Other ETags, e.g. of multipart uploads, return an empty string.
+func md5FromETag(etag string) string {
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != hex.EncodedLen(md5.Size) {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return strings.ToLower(etag)
+}
+
+// md5FromBase64 converts a base64-encoded MD5, e.g. a GS md5Hash,
+// to a hex-encoded MD5.
+func md5FromBase64(b64 string) string {
+	b, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(b) != md5.Size {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "funnel-test-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	p := path.Join(tmp, "test.txt")
+	ioutil.WriteFile(p, []byte("test"), os.ModePerm)
+
+	sum, err := Checksum(p, MD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != "098f6bcd4621d373cade4e832627b4f6" {
+		t.Error("unexpected md5", sum)
+	}
+
+	sum, err = Checksum(p, SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+		t.Error("unexpected sha256", sum)
+	}
+
+	if _, err := Checksum(p, "crc32"); err == nil {
+		t.Error("expected error for unknown algorithm")
+	}
+	if _, err := Checksum(path.Join(tmp, "missing.txt"), MD5); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestNativeMD5(t *testing.T) {
+	if s := md5FromETag(`"098F6BCD4621D373CADE4E832627B4F6"`); s != "098f6bcd4621d373cade4e832627b4f6" {
+		t.Error("unexpected md5 from single part ETag", s)
+	}
+	if s := md5FromETag(`"098f6bcd4621d373cade4e832627b4f6-2"`); s != "" {
+		t.Error("expected no md5 from multipart ETag", s)
+	}
+	if s := md5FromBase64("CY9rzUYh03PK3k6DJie09g=="); s != "098f6bcd4621d373cade4e832627b4f6" {
+		t.Error("unexpected md5 from base64", s)
+	}
+	if s := md5FromBase64("not base64"); s != "" {
+		t.Error("expected no md5 from invalid base64", s)
+	}
+}
+
+func TestPutChecksum(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	os.MkdirAll(path.Join(tmp, "src", "dir"), os.ModePerm)
+	ioutil.WriteFile(path.Join(tmp, "src", "a.txt"), []byte("test"), os.ModePerm)
+	ioutil.WriteFile(path.Join(tmp, "src", "dir", "b.txt"), []byte(""), os.ModePerm)
+
+	l, _ := NewLocalBackend(config.LocalStorage{AllowedDirs: []string{tmp}})
+	store := Storage{}.WithBackend(l)
+
+	_, sums, err := store.PutChecksum(ctx, "file://"+tmp+"/dst/a.txt", path.Join(tmp, "src", "a.txt"), tes.FileType_FILE, MD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sums["file://"+tmp+"/dst/a.txt"] != "md5:098f6bcd4621d373cade4e832627b4f6" {
+		t.Error("unexpected file checksums", sums)
+	}
+
+	_, sums, err = store.PutChecksum(ctx, "file://"+tmp+"/dst/dir", path.Join(tmp, "src", "dir"), tes.FileType_DIRECTORY, MD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 || sums["file://"+tmp+"/dst/dir/b.txt"] != "md5:d41d8cd98f00b204e9800998ecf8427e" {
+		t.Error("unexpected directory checksums", sums)
+	}
+
+	_, sums, err = store.PutChecksum(ctx, "file://"+tmp+"/dst/a.txt", path.Join(tmp, "src", "a.txt"), tes.FileType_FILE, "")
+	if err != nil || sums != nil {
+		t.Error("expected no checksums", sums, err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"github.com/ohsu-comp-bio/funnel/util"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/storage/v1"
 	"io"
 	"io/ioutil"
@@ -17,6 +18,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 )
 
 // The gs url protocol
@@ -134,6 +136,74 @@ func (gs *GSBackend) PutFile(ctx context.Context, rawurl string, hostPath string
 	return err
 }
 
+// Version returns the generation of a GS object.
+func (gs *GSBackend) Version(ctx context.Context, rawurl string) (string, error) {
+	url, perr := parse(rawurl)
+	if perr != nil {
+		return "", perr
+	}
+
+	obj, err := gs.svc.Objects.Get(url.bucket, url.path).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", obj.Generation), nil
+}
+
+// Stat returns information about a GS object.
+func (gs *GSBackend) Stat(ctx context.Context, rawurl string) (*Object, error) {
+	url, perr := parse(rawurl)
+	if perr != nil {
+		return nil, perr
+	}
+
+	obj, err := gs.svc.Objects.Get(url.bucket, url.path).Context(ctx).Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gsObject(url.bucket, obj), nil
+}
+
+// List returns all the GS objects under the given url prefix.
+func (gs *GSBackend) List(ctx context.Context, rawurl string) ([]*Object, error) {
+	url, perr := parse(rawurl)
+	if perr != nil {
+		return nil, perr
+	}
+
+	var objects []*Object
+	prefix := strings.TrimSuffix(url.path, "/") + "/"
+	err := gs.svc.Objects.List(url.bucket).Prefix(prefix).Pages(ctx, func(page *storage.Objects) error {
+		for _, obj := range page.Items {
+			// Skip directory markers, e.g. "dir/".
+			if strings.HasSuffix(obj.Name, "/") {
+				continue
+			}
+			objects = append(objects, gsObject(url.bucket, obj))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func gsObject(bucket string, obj *storage.Object) *Object {
+	// Updated is an RFC 3339 timestamp.
+	modified, _ := time.Parse(time.RFC3339, obj.Updated)
+	return &Object{
+		URL:          gsscheme + "://" + bucket + "/" + obj.Name,
+		Size:         int64(obj.Size),
+		LastModified: modified,
+		ETag:         obj.Etag,
+		MD5:          md5FromBase64(obj.Md5Hash),
+	}
+}
+
 // Supports returns true if this backend supports the given storage request.
 // The Google Storage backend supports URLs which have a "gs://" scheme.
 func (gs *GSBackend) Supports(rawurl string, hostPath string, class tes.FileType) bool {
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/util"
+	"io"
+	"net/http"
+	urllib "net/url"
+	"os"
+	"strings"
+)
+
+// httpMaxResumes is the maximum number of times an interrupted download
+// is resumed before giving up.
+const httpMaxResumes = 5
+
+// HTTPBackend provides read-only access to files served over HTTP(S),
+// e.g. public reference data. Files can be downloaded, but not uploaded.
+//
+// Only the hosts in conf.AllowedHosts can be accessed, so that tasks can't
+// make the worker fetch arbitrary URLs, e.g. on the worker's private network.
+type HTTPBackend struct {
+	client *http.Client
+	conf   config.HTTPStorage
+}
+
+// NewHTTPBackend creates an HTTPBackend client instance. Redirects are
+// followed by the client, as long as they stay on the allowed hosts.
+// The credentials are dropped when a request is redirected to a URL
+// they aren't sent to.
+func NewHTTPBackend(conf config.HTTPStorage) (*HTTPBackend, error) {
+	h := &HTTPBackend{conf: conf}
+	h.client = &http.Client{CheckRedirect: h.checkRedirect}
+	return h, nil
+}
+
+// checkRedirect rejects redirects to hosts which aren't allowed,
+// and drops the credentials from redirects which shouldn't have them.
+func (h *HTTPBackend) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	if !hostAllowed(req.URL, h.conf.AllowedHosts) {
+		return fmt.Errorf("HTTP storage redirected to a host which is not allowed: %s", req.URL.Host)
+	}
+	if !h.sendsAuth(req.URL) {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// Get downloads a file from an HTTP(S) URL to the host path.
+// An interrupted download is resumed with a range request, as long as
+// the server's ETag or Last-Modified header shows the file hasn't changed.
+func (h *HTTPBackend) Get(ctx context.Context, rawurl string, hostPath string, class tes.FileType) error {
+	if _, err := h.parseAllowed(rawurl); err != nil {
+		return err
+	}
+	if class != File {
+		return fmt.Errorf("HTTP storage only supports files, can't download directory: %s", rawurl)
+	}
+
+	util.EnsurePath(hostPath)
+	dest, cerr := os.Create(hostPath)
+	if cerr != nil {
+		return cerr
+	}
+
+	err := h.download(ctx, rawurl, dest)
+	if cerr := dest.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// download writes the body of the file at "rawurl" to "dest",
+// resuming the download if it is interrupted.
+func (h *HTTPBackend) download(ctx context.Context, rawurl string, dest *os.File) error {
+	var written int64
+	// validator is the ETag or Last-Modified header of the file,
+	// which is used to check that the file hasn't changed when resuming.
+	var validator string
+
+	for resumes := 0; ; resumes++ {
+		resp, err := h.request(ctx, rawurl, written, validator)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case written > 0 && resp.StatusCode == http.StatusPartialContent:
+		case resp.StatusCode == http.StatusOK:
+			// Either this is the first request, or the server doesn't
+			// support ranges or the file changed, so start over.
+			if written > 0 {
+				if err := restart(dest); err != nil {
+					resp.Body.Close()
+					return err
+				}
+				written = 0
+			}
+			validator = httpValidator(resp)
+		default:
+			resp.Body.Close()
+			return &httpStatusError{"download", rawurl, resp.StatusCode, resp.Status}
+		}
+
+		n, err := io.Copy(dest, resp.Body)
+		resp.Body.Close()
+		written += n
+
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if validator == "" || resumes >= httpMaxResumes {
+			return fmt.Errorf("failed to download %s: %s", rawurl, err)
+		}
+	}
+}
+
+// request sends a GET request for the file at "rawurl". If "offset" is
+// greater than zero, only the rest of the file is requested, if the file
+// still matches "validator".
+func (h *HTTPBackend) request(ctx context.Context, rawurl string, offset int64, validator string) (*http.Response, error) {
+	req, err := h.newRequest(ctx, "GET", rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+
+	return h.client.Do(req)
+}
+
+// newRequest creates a request, with the configured credentials
+// if they are sent to the request's URL.
+func (h *HTTPBackend) newRequest(ctx context.Context, method string, rawurl string) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if !h.sendsAuth(req.URL) {
+		return req, nil
+	}
+	if h.conf.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.conf.BearerToken)
+	} else if h.conf.Username != "" || h.conf.Password != "" {
+		req.SetBasicAuth(h.conf.Username, h.conf.Password)
+	}
+	return req, nil
+}
+
+// sendsAuth returns true if the credentials are sent to the given URL:
+// it must use https, and its host must be one of conf.AuthHosts.
+// Credentials are never sent over plain http.
+func (h *HTTPBackend) sendsAuth(url *urllib.URL) bool {
+	return url.Scheme == "https" && hostMatches(url, h.conf.AuthHosts)
+}
+
+// hostAllowed returns true if the URL's host is in the allowed hosts.
+// "*" allows any host.
+func hostAllowed(url *urllib.URL, allowed []string) bool {
+	for _, a := range allowed {
+		if a == "*" {
+			return true
+		}
+	}
+	return hostMatches(url, allowed)
+}
+
+// hostMatches returns true if the URL's host is one of the given hosts.
+// A host without a port matches any port.
+func hostMatches(url *urllib.URL, hosts []string) bool {
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if host == strings.ToLower(url.Host) || host == strings.ToLower(url.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpValidator returns a validator for the If-Range header of a resumed
+// request: a strong ETag, or else the Last-Modified time. An empty string
+// means the download can't be resumed safely.
+func httpValidator(resp *http.Response) string {
+	etag := resp.Header.Get("ETag")
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// restart truncates a partially downloaded file.
+func restart(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// httpStatusError is returned for an unexpected HTTP response status.
+type httpStatusError struct {
+	op     string
+	url    string
+	code   int
+	status string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("failed to %s %s: %s", e.op, e.url, e.status)
+}
+
+// StatusCode returns the HTTP status code of the response.
+func (e *httpStatusError) StatusCode() int {
+	return e.code
+}
+
+// PutFile always fails, because HTTP storage is read-only.
+func (h *HTTPBackend) PutFile(ctx context.Context, rawurl string, hostPath string) error {
+	return fmt.Errorf("HTTP storage is read-only, can't upload to: %s", rawurl)
+}
+
+// Stat returns information about the file at an HTTP(S) URL,
+// from the headers of a HEAD request.
+func (h *HTTPBackend) Stat(ctx context.Context, rawurl string) (*Object, error) {
+	if _, err := h.parseAllowed(rawurl); err != nil {
+		return nil, err
+	}
+
+	req, err := h.newRequest(ctx, "HEAD", rawurl)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{"stat", rawurl, resp.StatusCode, resp.Status}
+	}
+
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &Object{
+		URL:          rawurl,
+		Size:         resp.ContentLength,
+		LastModified: modified,
+		ETag:         resp.Header.Get("ETag"),
+	}, nil
+}
+
+// List always fails, because there's no standard way to list
+// a directory over HTTP.
+func (h *HTTPBackend) List(ctx context.Context, rawurl string) ([]*Object, error) {
+	return nil, fmt.Errorf("HTTP storage doesn't support listing directories: %s", rawurl)
+}
+
+func (h *HTTPBackend) parse(rawurl string) (*urllib.URL, error) {
+	url, err := urllib.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if url.Scheme != "http" && url.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid URL scheme '%s' for HTTP Storage backend in url: %s", url.Scheme, rawurl)
+	}
+	if url.Host == "" {
+		return nil, fmt.Errorf("Missing host for HTTP Storage backend in url: %s", rawurl)
+	}
+	return url, nil
+}
+
+// parseAllowed parses the URL, and checks that its host is allowed.
+func (h *HTTPBackend) parseAllowed(rawurl string) (*urllib.URL, error) {
+	url, err := h.parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if !hostAllowed(url, h.conf.AllowedHosts) {
+		return nil, fmt.Errorf("Can't access URL, host is not in the allowed hosts of the HTTP Storage backend: %s", rawurl)
+	}
+	return url, nil
+}
+
+// Supports indicates whether this backend supports the given storage request.
+// For HTTP, the url must start with "http://" or "https://", and the class
+// must be File, since there's no standard way to list a directory.
+func (h *HTTPBackend) Supports(rawurl string, hostPath string, class tes.FileType) bool {
+	_, err := h.parse(rawurl)
+	return err == nil && class == File
+}
+
+// SupportsPut always returns false, because HTTP storage is read-only.
+func (h *HTTPBackend) SupportsPut(rawurl string, hostPath string, class tes.FileType) bool {
+	return false
+}
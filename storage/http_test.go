@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	urllib "net/url"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHTTPGet(t *testing.T) {
+	ctx := context.Background()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/file.txt", http.StatusFound)
+	})
+	mux.HandleFunc("/file.txt", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "funnel" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("hello\n"))
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+
+	tmp, err := ioutil.TempDir("", "funnel-test-http-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	h := newTestHTTPBackend(srv, config.HTTPStorage{
+		Username:  "funnel",
+		Password:  "secret",
+		AuthHosts: []string{serverHost(srv)},
+	})
+	store := Storage{}.WithBackend(h)
+
+	dest := path.Join(tmp, "file.txt")
+	err = store.Get(ctx, srv.URL+"/redirect", dest, tes.FileType_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(dest)
+	if string(b) != "hello\n" {
+		t.Errorf("unexpected content: %q", b)
+	}
+
+	h = newTestHTTPBackend(srv, config.HTTPStorage{})
+	err = h.Get(ctx, srv.URL+"/file.txt", dest, tes.FileType_FILE)
+	if err == nil {
+		t.Error("expected error without credentials")
+	}
+}
+
+// newTestHTTPBackend returns an HTTPBackend which trusts the test server's
+// certificate, and is allowed to access the test server.
+func newTestHTTPBackend(srv *httptest.Server, conf config.HTTPStorage) *HTTPBackend {
+	conf.AllowedHosts = append(conf.AllowedHosts, serverHost(srv))
+	h, _ := NewHTTPBackend(conf)
+	h.client.Transport = srv.Client().Transport
+	return h
+}
+
+func serverHost(srv *httptest.Server) string {
+	u, _ := urllib.Parse(srv.URL)
+	return u.Host
+}
+
+// Test that the credentials are only sent to the auth hosts, over https.
+func TestHTTPAuthHosts(t *testing.T) {
+	ctx := context.Background()
+	var auth []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.Write([]byte("hello\n"))
+	})
+	srv := httptest.NewTLSServer(handler)
+	defer srv.Close()
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	tmp, err := ioutil.TempDir("", "funnel-test-http-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dest := path.Join(tmp, "file.txt")
+
+	// Not an auth host.
+	h := newTestHTTPBackend(srv, config.HTTPStorage{
+		BearerToken: "token",
+		AuthHosts:   []string{"example.com"},
+	})
+	err = h.Get(ctx, srv.URL+"/file.txt", dest, tes.FileType_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An auth host, but over plain http.
+	h = newTestHTTPBackend(plain, config.HTTPStorage{
+		BearerToken: "token",
+		AuthHosts:   []string{serverHost(plain)},
+	})
+	err = h.Get(ctx, plain.URL+"/file.txt", dest, tes.FileType_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(auth) != 2 || auth[0] != "" || auth[1] != "" {
+		t.Errorf("expected no credentials to be sent, got %q", auth)
+	}
+}
+
+// Test that hosts which aren't allowed can't be accessed,
+// and that the backend is disabled without allowed hosts.
+func TestHTTPAllowedHosts(t *testing.T) {
+	ctx := context.Background()
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://example.com/file.txt", http.StatusFound)
+			return
+		}
+		w.Write([]byte("hello\n"))
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempDir("", "funnel-test-http-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dest := path.Join(tmp, "file.txt")
+
+	h, _ := NewHTTPBackend(config.HTTPStorage{AllowedHosts: []string{"example.com"}})
+	err = h.Get(ctx, srv.URL+"/file.txt", dest, tes.FileType_FILE)
+	if err == nil {
+		t.Error("expected error for a host which isn't allowed")
+	}
+	_, err = h.Stat(ctx, srv.URL+"/file.txt")
+	if err == nil {
+		t.Error("expected error for a host which isn't allowed")
+	}
+	if requests != 0 {
+		t.Error("unexpected requests to a host which isn't allowed", requests)
+	}
+
+	h = newTestHTTPBackend(srv, config.HTTPStorage{})
+	err = h.Get(ctx, srv.URL+"/redirect", dest, tes.FileType_FILE)
+	if err == nil {
+		t.Error("expected error for a redirect to a host which isn't allowed")
+	}
+
+	if (config.HTTPStorage{}).Valid() {
+		t.Error("expected HTTP storage without allowed hosts to be disabled")
+	}
+}
+
+func TestHTTPGetBearerToken(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("hello\n"))
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempDir("", "funnel-test-http-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	h := newTestHTTPBackend(srv, config.HTTPStorage{
+		BearerToken: "token",
+		AuthHosts:   []string{serverHost(srv)},
+	})
+	err = h.Get(ctx, srv.URL+"/file.txt", path.Join(tmp, "file.txt"), tes.FileType_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test that an interrupted download is resumed with a range request.
+func TestHTTPGetResume(t *testing.T) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	modtime := time.Now()
+	requests := 0
+	ranges := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("Range") != "" {
+			ranges++
+		}
+		if requests == 1 {
+			// Send part of the file, then close the connection.
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			return
+		}
+		http.ServeContent(w, r, "file.txt", modtime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempDir("", "funnel-test-http-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	h := newTestHTTPBackend(srv, config.HTTPStorage{})
+	dest := path.Join(tmp, "file.txt")
+	err = h.Get(ctx, srv.URL+"/file.txt", dest, tes.FileType_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadFile(dest)
+	if !bytes.Equal(b, content) {
+		t.Errorf("unexpected content, got %d bytes", len(b))
+	}
+	if requests != 2 || ranges != 1 {
+		t.Errorf("expected 2 requests and 1 range request, got %d and %d", requests, ranges)
+	}
+}
+
+func TestHTTPSupports(t *testing.T) {
+	h, _ := NewHTTPBackend(config.HTTPStorage{})
+	store := Storage{}.WithBackend(h)
+
+	if !store.Supports("https://example.com/file.txt", "", tes.FileType_FILE) {
+		t.Error("expected https file input to be supported")
+	}
+	if store.Supports("https://example.com/dir", "", tes.FileType_DIRECTORY) {
+		t.Error("expected https directory input to be unsupported")
+	}
+	if store.Supports("s3://bucket/file.txt", "", tes.FileType_FILE) {
+		t.Error("expected s3 url to be unsupported")
+	}
+	if store.SupportsPut("https://example.com/file.txt", "", tes.FileType_FILE) {
+		t.Error("expected https output to be unsupported")
+	}
+
+	_, err := store.Put(context.Background(), "https://example.com/file.txt", "file.txt", tes.FileType_FILE)
+	if err == nil {
+		t.Error("expected error uploading to https url")
+	}
+}
+
+func TestHTTPStat(t *testing.T) {
+	ctx := context.Background()
+	modtime := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.txt", modtime, bytes.NewReader([]byte("hello\n")))
+	}))
+	defer srv.Close()
+
+	h := newTestHTTPBackend(srv, config.HTTPStorage{})
+	store := Storage{}.WithBackend(h)
+
+	obj, err := store.Stat(ctx, srv.URL+"/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Size != 6 || obj.ETag != `"v1"` || !obj.LastModified.Equal(modtime) {
+		t.Error("unexpected object", obj)
+	}
+
+	ok, err := store.Exists(ctx, srv.URL+"/missing.txt")
+	if err != nil || ok {
+		t.Error("expected file to not exist", err)
+	}
+}
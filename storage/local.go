@@ -83,6 +83,62 @@ func (local *LocalBackend) PutFile(ctx context.Context, url string, hostPath str
 	return linkFile(hostPath, path)
 }
 
+// Stat returns information about the file at the given url.
+func (local *LocalBackend) Stat(ctx context.Context, url string) (*Object, error) {
+	path, ok := getPath(url)
+
+	if !ok {
+		return nil, fmt.Errorf("local storage does not support stat on %s", url)
+	}
+
+	if !isAllowed(path, local.allowedDirs) {
+		return nil, fmt.Errorf("Can't access file, path is not in allowed directories:  %s", path)
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", url)
+	}
+	return &Object{URL: url, Size: fi.Size(), LastModified: fi.ModTime()}, nil
+}
+
+// List returns all the files under the directory at the given url.
+func (local *LocalBackend) List(ctx context.Context, url string) ([]*Object, error) {
+	path, ok := getPath(url)
+
+	if !ok {
+		return nil, fmt.Errorf("local storage does not support list on %s", url)
+	}
+
+	if !isAllowed(path, local.allowedDirs) {
+		return nil, fmt.Errorf("Can't access directory, path is not in allowed directories:  %s", path)
+	}
+
+	files, err := walkFiles(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*Object
+	for _, f := range files {
+		objects = append(objects, &Object{
+			URL:          strings.TrimSuffix(url, "/") + "/" + filepath.ToSlash(f.rel),
+			Size:         f.size,
+			LastModified: f.modTime,
+		})
+	}
+	return objects, nil
+}
+
 // Supports indicates whether this backend supports the given storage request.
 // For the LocalBackend, the url must start with "file://"
 func (local *LocalBackend) Supports(rawurl string, hostPath string, class tes.FileType) bool {
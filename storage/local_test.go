@@ -177,6 +177,62 @@ func TestLocalPutPath(t *testing.T) {
 // Tests Put when source and dest reference the same file (inode)
 // Since the LocalBackend hard-links files when possible we need to protect
 // against the case where the same path is 'Put' twice
+// Tests Stat, Exists and List on "file://" URLs.
+func TestLocalStatList(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-local-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	l := Storage{}.WithBackend(&LocalBackend{allowedDirs: []string{tmp}})
+
+	os.MkdirAll(path.Join(tmp, "dir", "sub"), os.ModePerm)
+	ioutil.WriteFile(path.Join(tmp, "dir", "a.txt"), []byte("foo"), os.ModePerm)
+	ioutil.WriteFile(path.Join(tmp, "dir", "sub", "b.txt"), []byte("barbaz"), os.ModePerm)
+
+	obj, err := l.Stat(ctx, "file://"+tmp+"/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Size != 3 || obj.LastModified.IsZero() {
+		t.Error("unexpected object", obj)
+	}
+
+	_, err = l.Stat(ctx, "file://"+tmp+"/missing.txt")
+	if err != ErrNotFound {
+		t.Error("expected ErrNotFound, got", err)
+	}
+
+	ok, err := l.Exists(ctx, "file://"+tmp+"/dir/a.txt")
+	if err != nil || !ok {
+		t.Error("expected file to exist", err)
+	}
+	ok, err = l.Exists(ctx, "file://"+tmp+"/missing.txt")
+	if err != nil || ok {
+		t.Error("expected file to not exist", err)
+	}
+
+	objs, err := l.List(ctx, "file://"+tmp+"/dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Fatal("unexpected objects", objs)
+	}
+	if objs[0].URL != "file://"+tmp+"/dir/a.txt" || objs[0].Size != 3 {
+		t.Error("unexpected object", objs[0])
+	}
+	if objs[1].URL != "file://"+tmp+"/dir/sub/b.txt" || objs[1].Size != 6 {
+		t.Error("unexpected object", objs[1])
+	}
+
+	_, err = l.Stat(ctx, "file:///etc/passwd")
+	if err == nil {
+		t.Error("expected error for path outside allowed dirs")
+	}
+}
+
 func TestSameFile(t *testing.T) {
 	tmp, err := ioutil.TempDir("", "funnel-test-local-storage")
 	if err != nil {
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"github.com/ncw/swift"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"google.golang.org/api/googleapi"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// Retry describes a retry of a failed storage request.
+type Retry struct {
+	// The request, e.g. "Get" or "PutFile".
+	Op  string
+	URL string
+	// The attempt which failed, starting at 1.
+	Attempt int
+	// How long to wait before the next attempt.
+	Backoff time.Duration
+	// The error of the failed attempt.
+	Err error
+}
+
+// RetryNotify is called before each retry of a failed storage request,
+// e.g. to log the retry.
+type RetryNotify func(Retry)
+
+// RetryBackend wraps a Backend, retrying requests which fail with a transient
+// error, e.g. a 503 response or a connection reset, see Retryable.
+// The wait between attempts grows exponentially, with random jitter.
+type RetryBackend struct {
+	Backend
+	Conf config.StorageRetryPolicy
+	// Notify is optional.
+	Notify RetryNotify
+}
+
+// withRetry wraps the backend in a RetryBackend, if the policy allows retries.
+func withRetry(b Backend, conf config.StorageRetryPolicy) Backend {
+	if conf.MaxAttempts <= 1 {
+		return b
+	}
+	return &RetryBackend{Backend: b, Conf: conf}
+}
+
+// Get calls Get of the wrapped backend, retrying transient errors.
+func (r *RetryBackend) Get(ctx context.Context, url string, path string, class tes.FileType) error {
+	return r.retry(ctx, "Get", url, func() error {
+		return r.Backend.Get(ctx, url, path, class)
+	})
+}
+
+// PutFile calls PutFile of the wrapped backend, retrying transient errors.
+func (r *RetryBackend) PutFile(ctx context.Context, url string, path string) error {
+	return r.retry(ctx, "PutFile", url, func() error {
+		return r.Backend.PutFile(ctx, url, path)
+	})
+}
+
+// Stat calls Stat of the wrapped backend, retrying transient errors.
+func (r *RetryBackend) Stat(ctx context.Context, url string) (*Object, error) {
+	var obj *Object
+	err := r.retry(ctx, "Stat", url, func() error {
+		var err error
+		obj, err = r.Backend.Stat(ctx, url)
+		return err
+	})
+	return obj, err
+}
+
+// List calls List of the wrapped backend, retrying transient errors.
+func (r *RetryBackend) List(ctx context.Context, url string) ([]*Object, error) {
+	var objs []*Object
+	err := r.retry(ctx, "List", url, func() error {
+		var err error
+		objs, err = r.Backend.List(ctx, url)
+		return err
+	})
+	return objs, err
+}
+
+// Version calls Version of the wrapped backend, retrying transient errors.
+// If the wrapped backend doesn't support versions, ErrNoVersion is returned.
+func (r *RetryBackend) Version(ctx context.Context, url string) (string, error) {
+	v, ok := r.Backend.(Versioner)
+	if !ok {
+		return "", ErrNoVersion
+	}
+
+	var version string
+	err := r.retry(ctx, "Version", url, func() error {
+		var err error
+		version, err = v.Version(ctx, url)
+		return err
+	})
+	return version, err
+}
+
+// SupportsPut indicates whether the wrapped backend supports uploading to
+// the given url, see PutSupporter.
+func (r *RetryBackend) SupportsPut(url string, path string, class tes.FileType) bool {
+	return supportsPut(r.Backend, url, path, class)
+}
+
+// retry calls "fn" until it succeeds, fails with an error which isn't
+// retryable, or the attempts run out.
+func (r *RetryBackend) retry(ctx context.Context, op string, url string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.Conf.MaxAttempts || ctx.Err() != nil || !Retryable(err) {
+			return err
+		}
+
+		wait := r.backoff(attempt)
+		if r.Notify != nil {
+			r.Notify(Retry{Op: op, URL: url, Attempt: attempt, Backoff: wait, Err: err})
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// backoff returns how long to wait after the given failed attempt.
+// The wait doubles with each attempt, up to Conf.MaxBackoff, and is
+// randomized between half and all of it, so that the retries of parallel
+// requests are spread out.
+func (r *RetryBackend) backoff(attempt int) time.Duration {
+	wait := r.Conf.Backoff
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if r.Conf.MaxBackoff > 0 && wait >= r.Conf.MaxBackoff {
+			break
+		}
+	}
+	if r.Conf.MaxBackoff > 0 && wait > r.Conf.MaxBackoff {
+		wait = r.Conf.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// transientErrors are parts of error messages of transient errors, for errors
+// which are only available as strings, e.g. the wrapped errors of the AWS
+// and Swift clients.
+var transientErrors = []string{
+	"connection reset",
+	"broken pipe",
+	"unexpected eof",
+	"timeout",
+}
+
+// Retryable returns true if the error of a storage request is likely
+// transient, so that the request may succeed if it is retried:
+// HTTP 408, 429 and 5xx responses, network timeouts, connection resets
+// and truncated responses. Canceled requests and missing objects
+// aren't retryable.
+func Retryable(err error) bool {
+	switch err {
+	case nil, context.Canceled, context.DeadlineExceeded, ErrNotFound, ErrNoVersion:
+		return false
+	case io.ErrUnexpectedEOF:
+		return true
+	}
+
+	switch e := err.(type) {
+	case *googleapi.Error:
+		return retryableStatus(e.Code)
+	case *swift.Error:
+		if e.StatusCode != 0 {
+			return retryableStatus(e.StatusCode)
+		}
+	case interface {
+		StatusCode() int
+	}:
+		// S3 request failures and HTTP responses.
+		return retryableStatus(e.StatusCode())
+	case net.Error:
+		if e.Timeout() || e.Temporary() {
+			return true
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableStatus returns true if the HTTP status code is likely transient.
+func retryableStatus(code int) bool {
+	switch {
+	case code == 408, code == 429:
+		return true
+	case code >= 500 && code != 501:
+		return true
+	}
+	return false
+}
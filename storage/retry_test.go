@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"google.golang.org/api/googleapi"
+	"io"
+	"testing"
+	"time"
+)
+
+// flakyBackend fails the first "failures" requests with "err".
+type flakyBackend struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyBackend) do() error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+func (f *flakyBackend) Get(ctx context.Context, url string, path string, class tes.FileType) error {
+	return f.do()
+}
+
+func (f *flakyBackend) PutFile(ctx context.Context, url string, path string) error {
+	return f.do()
+}
+
+func (f *flakyBackend) Stat(ctx context.Context, url string) (*Object, error) {
+	if err := f.do(); err != nil {
+		return nil, err
+	}
+	return &Object{URL: url}, nil
+}
+
+func (f *flakyBackend) List(ctx context.Context, url string) ([]*Object, error) {
+	return nil, f.do()
+}
+
+func (f *flakyBackend) Supports(url string, path string, class tes.FileType) bool {
+	return true
+}
+
+var testRetryPolicy = config.StorageRetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     time.Millisecond,
+	MaxBackoff:  time.Millisecond * 10,
+}
+
+func TestRetryBackend(t *testing.T) {
+	ctx := context.Background()
+	f := &flakyBackend{failures: 2, err: &googleapi.Error{Code: 503}}
+
+	var retries []Retry
+	s := Storage{}.WithBackend(withRetry(f, testRetryPolicy)).WithRetryNotify(func(r Retry) {
+		retries = append(retries, r)
+	})
+
+	err := s.Get(ctx, "gs://bucket/file", "file", tes.FileType_FILE)
+	if err != nil {
+		t.Fatal("expected the request to succeed after retries", err)
+	}
+	if f.calls != 3 {
+		t.Error("expected 3 attempts, got", f.calls)
+	}
+	if len(retries) != 2 {
+		t.Fatal("expected 2 retries, got", len(retries))
+	}
+	if retries[0].Op != "Get" || retries[0].URL != "gs://bucket/file" || retries[0].Attempt != 1 || retries[1].Attempt != 2 {
+		t.Error("unexpected retries", retries)
+	}
+
+	// The attempts run out.
+	f = &flakyBackend{failures: 5, err: io.ErrUnexpectedEOF}
+	s = Storage{}.WithBackend(withRetry(f, testRetryPolicy))
+	_, err = s.Stat(ctx, "gs://bucket/file")
+	if err != io.ErrUnexpectedEOF || f.calls != 3 {
+		t.Error("expected the request to fail after 3 attempts", err, f.calls)
+	}
+
+	// Errors which aren't transient aren't retried.
+	f = &flakyBackend{failures: 5, err: ErrNotFound}
+	s = Storage{}.WithBackend(withRetry(f, testRetryPolicy))
+	_, err = s.Stat(ctx, "gs://bucket/file")
+	if err != ErrNotFound || f.calls != 1 {
+		t.Error("expected the request to fail without retries", err, f.calls)
+	}
+
+	// Retries are disabled by default.
+	if _, ok := withRetry(f, config.StorageRetryPolicy{}).(*RetryBackend); ok {
+		t.Error("expected retries to be disabled")
+	}
+}
+
+func TestRetryBackendForwards(t *testing.T) {
+	ctx := context.Background()
+	s := Storage{}.WithBackend(withRetry(&flakyBackend{}, testRetryPolicy))
+	if _, err := s.Version(ctx, "gs://bucket/file"); err != ErrNoVersion {
+		t.Error("expected ErrNoVersion", err)
+	}
+
+	h, _ := NewHTTPBackend(config.HTTPStorage{})
+	s = Storage{}.WithBackend(withRetry(h, testRetryPolicy))
+	if s.SupportsPut("https://example.com/file", "file", tes.FileType_FILE) {
+		t.Error("expected uploads to HTTP(S) to be unsupported")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	r := &RetryBackend{Conf: config.StorageRetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Second * 5,
+	}}
+	for i, max := range []time.Duration{1, 2, 4, 5, 5} {
+		attempt := i + 1
+		max *= time.Second
+		wait := r.backoff(attempt)
+		if wait < max/2 || wait > max {
+			t.Errorf("backoff of attempt %d: expected between %s and %s, got %s", attempt, max/2, max, wait)
+		}
+	}
+}
+
+type statusError int
+
+func (e statusError) Error() string   { return fmt.Sprintf("status %d", int(e)) }
+func (e statusError) StatusCode() int { return int(e) }
+
+func TestRetryable(t *testing.T) {
+	retryable := []error{
+		io.ErrUnexpectedEOF,
+		&googleapi.Error{Code: 503},
+		&googleapi.Error{Code: 429},
+		statusError(500),
+		statusError(408),
+		errors.New("read tcp 10.0.0.1:1234: read: connection reset by peer"),
+		errors.New("write: broken pipe"),
+	}
+	for _, err := range retryable {
+		if !Retryable(err) {
+			t.Errorf("expected %q to be retryable", err)
+		}
+	}
+
+	permanent := []error{
+		nil,
+		context.Canceled,
+		context.DeadlineExceeded,
+		ErrNotFound,
+		&googleapi.Error{Code: 404},
+		&googleapi.Error{Code: 403},
+		statusError(501),
+		statusError(400),
+		errors.New("Can't access file, path is not in allowed directories"),
+	}
+	for _, err := range permanent {
+		if Retryable(err) {
+			t.Errorf("expected %v not to be retryable", err)
+		}
+	}
+}
@@ -160,6 +160,126 @@ func (s3b *S3Backend) PutFile(ctx context.Context, url string, hostPath string)
 	return fh.Close()
 }
 
+// Version returns the ETag of an S3 object.
+func (s3b *S3Backend) Version(ctx context.Context, url string) (string, error) {
+
+	path := strings.TrimPrefix(url, S3Protocol)
+	split := strings.SplitN(path, "/", 2)
+	bucket := split[0]
+	key := split[1]
+
+	region, err := s3manager.GetBucketRegion(ctx, s3b.sess, bucket, "us-east-1")
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return "", fmt.Errorf("unable to find bucket %s's region not found", bucket)
+		}
+		return "", err
+	}
+
+	sess := s3b.sess.Copy(&aws.Config{Region: aws.String(region)})
+	client := s3.New(sess)
+
+	obj, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(obj.ETag), nil
+}
+
+// Stat returns information about an S3 object.
+func (s3b *S3Backend) Stat(ctx context.Context, url string) (*Object, error) {
+	bucket, key := s3Parse(url)
+
+	client, err := s3b.client(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 404 {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{
+		URL:          url,
+		Size:         aws.Int64Value(obj.ContentLength),
+		LastModified: aws.TimeValue(obj.LastModified),
+		ETag:         aws.StringValue(obj.ETag),
+		MD5:          md5FromETag(aws.StringValue(obj.ETag)),
+	}, nil
+}
+
+// List returns all the S3 objects under the given url prefix.
+func (s3b *S3Backend) List(ctx context.Context, url string) ([]*Object, error) {
+	bucket, key := s3Parse(url)
+	prefix := strings.TrimSuffix(key, "/") + "/"
+
+	client, err := s3b.client(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*Object
+	err = client.ListObjectsV2PagesWithContext(
+		ctx,
+		&s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)},
+		func(page *s3.ListObjectsV2Output, more bool) bool {
+			for _, obj := range page.Contents {
+				// Skip directory markers, e.g. "dir/".
+				if strings.HasSuffix(aws.StringValue(obj.Key), "/") {
+					continue
+				}
+				objects = append(objects, &Object{
+					URL:          S3Protocol + bucket + "/" + aws.StringValue(obj.Key),
+					Size:         aws.Int64Value(obj.Size),
+					LastModified: aws.TimeValue(obj.LastModified),
+					ETag:         aws.StringValue(obj.ETag),
+					MD5:          md5FromETag(aws.StringValue(obj.ETag)),
+				})
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// client returns an S3 client for the region of the given bucket.
+func (s3b *S3Backend) client(ctx context.Context, bucket string) (*s3.S3, error) {
+	region, err := s3manager.GetBucketRegion(ctx, s3b.sess, bucket, "us-east-1")
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return nil, fmt.Errorf("unable to find bucket %s's region not found", bucket)
+		}
+		return nil, err
+	}
+
+	sess := s3b.sess.Copy(&aws.Config{Region: aws.String(region)})
+	return s3.New(sess), nil
+}
+
+// s3Parse splits an S3 url into the bucket and key.
+func s3Parse(url string) (bucket string, key string) {
+	path := strings.TrimPrefix(url, S3Protocol)
+	split := strings.SplitN(path, "/", 2)
+	bucket = split[0]
+	if len(split) > 1 {
+		key = split[1]
+	}
+	return bucket, key
+}
+
 // Supports indicates whether this backend supports the given storage request.
 // For S3, the url must start with "s3://".
 func (s3b *S3Backend) Supports(url string, hostPath string, class tes.FileType) bool {
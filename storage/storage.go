@@ -8,12 +8,15 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/util"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
@@ -28,12 +31,53 @@ const (
 type Backend interface {
 	Get(ctx context.Context, url string, path string, class tes.FileType) error
 	PutFile(ctx context.Context, url string, path string) error
+	// Stat returns information about the object (file) at the given url.
+	// If the object doesn't exist, ErrNotFound is returned.
+	Stat(ctx context.Context, url string) (*Object, error)
+	// List returns all the objects (files) under the directory at the given url.
+	List(ctx context.Context, url string) ([]*Object, error)
 	// Determines whether this backends supports the given request (url/path/class).
 	// A backend normally uses this to match the url prefix (e.g. "s3://")
-	// TODO would it be useful if this included the request type (Get/Put)?
+	// Backends which can't upload to every url they support should also
+	// implement PutSupporter.
 	Supports(url string, path string, class tes.FileType) bool
 }
 
+// PutSupporter is implemented by backends which support uploads to fewer
+// urls than downloads, e.g. read-only backends such as HTTP.
+type PutSupporter interface {
+	SupportsPut(url string, path string, class tes.FileType) bool
+}
+
+// Object describes an object (file) in a storage system.
+type Object struct {
+	// The storage url of the object.
+	URL string
+	// Size of the object in bytes, or -1 if the size is unknown.
+	Size int64
+	// LastModified is the zero time if the storage system doesn't provide it.
+	LastModified time.Time
+	// ETag or checksum of the object, if the storage system provides one.
+	ETag string
+	// Hex-encoded MD5 of the object's content, if the storage system
+	// provides it, e.g. the S3 ETag of a single part upload or the GS md5Hash.
+	MD5 string
+}
+
+// ErrNotFound is returned by Stat when the object doesn't exist.
+var ErrNotFound = errors.New("storage object not found")
+
+// Versioner is implemented by backends which can report a version of an
+// object, such as an ETag or generation number. The version changes whenever
+// the object's content changes, so it can be used to validate cached copies.
+type Versioner interface {
+	Version(ctx context.Context, url string) (string, error)
+}
+
+// ErrNoVersion is returned by Storage.Version when the backend for a URL
+// doesn't support object versions.
+var ErrNoVersion = errors.New("storage backend doesn't support object versions")
+
 // Storage provides a client for accessing multiple storage systems,
 // i.e. for downloading/uploading task files from S3, GS, local disk, etc.
 //
@@ -41,6 +85,10 @@ type Backend interface {
 // e.g. "s3://my-bucket/file" will access the S3 backend.
 type Storage struct {
 	backends []Backend
+	// Limits the number of files transferred at once, see
+	// WithParallelTransfers. The limit is shared by the child instances.
+	// Nil if transfers aren't limited.
+	transfers chan struct{}
 }
 
 // Get downloads a file from a storage system at the given "url".
@@ -52,6 +100,11 @@ func (storage Storage) Get(ctx context.Context, url string, path string, class t
 		return err
 	}
 
+	release, err := storage.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
 	return backend.Get(ctx, url, path, class)
 }
 
@@ -59,47 +112,129 @@ func (storage Storage) Get(ctx context.Context, url string, path string, class t
 // The file is uploaded from the given local "path".
 // "class" is either "File" or "Directory".
 func (storage Storage) Put(ctx context.Context, url string, path string, class tes.FileType) ([]*tes.OutputFileLog, error) {
+	out, _, err := storage.PutChecksum(ctx, url, path, class, "")
+	return out, err
+}
+
+// PutChecksum is Put, but also computes the checksum of each uploaded file
+// with the given algorithm. The checksums are returned by the file's URL,
+// as "<algorithm>:<hex>". An empty algorithm disables the checksums.
+//
+// The backends upload from a path, not a stream, so the checksum is computed
+// by a separate read of the local file, concurrently with the upload. It's
+// the checksum of the local file, which the upload is assumed to match.
+func (storage Storage) PutChecksum(ctx context.Context, url string, path string, class tes.FileType, algorithm string) ([]*tes.OutputFileLog, map[string]string, error) {
+	if algorithm != "" && !ValidChecksum(algorithm) {
+		return nil, nil, fmt.Errorf("unknown checksum algorithm: %s", algorithm)
+	}
+
 	backend, err := storage.findBackend(url, path, class)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if !supportsPut(backend, url, path, class) {
+		return nil, nil, fmt.Errorf("Storage system for %s doesn't support uploads", url)
 	}
 
 	var out []*tes.OutputFileLog
+	var sums []string
 
 	switch class {
 	case File:
-		err = backend.PutFile(ctx, url, path)
+		sum, err := storage.putFile(ctx, backend, url, path, algorithm)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		out = append(out, &tes.OutputFileLog{
 			Url:       url,
 			Path:      path,
 			SizeBytes: fileSize(path),
 		})
+		sums = append(sums, sum)
 	case Directory:
 		var files []hostfile
 		files, err = walkFiles(path)
+		if err != nil {
+			return nil, nil, err
+		}
 
-		for _, f := range files {
+		out = make([]*tes.OutputFileLog, len(files))
+		sums = make([]string, len(files))
+		err = util.ParallelDo(ctx, len(files), cap(storage.transfers), func(ctx context.Context, i int) error {
+			f := files[i]
 			u := strings.TrimSuffix(url, "/") + "/" + f.rel
-			err = backend.PutFile(ctx, u, f.abs)
+			sum, err := storage.putFile(ctx, backend, u, f.abs, algorithm)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
-			out = append(out, &tes.OutputFileLog{
+			out[i] = &tes.OutputFileLog{
 				Url:       u,
 				Path:      f.abs,
 				SizeBytes: f.size,
-			})
+			}
+			sums[i] = sum
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
 		}
 
 	default:
-		return nil, fmt.Errorf("Unknown file class: %s", class)
+		return nil, nil, fmt.Errorf("Unknown file class: %s", class)
+	}
+
+	if algorithm == "" {
+		return out, nil, nil
+	}
+	checksums := map[string]string{}
+	for i, o := range out {
+		checksums[o.Url] = algorithm + ":" + sums[i]
+	}
+	return out, checksums, nil
+}
+
+// Version returns the version (e.g. ETag) of the object at the given "url".
+// If the backend doesn't support versions, ErrNoVersion is returned.
+func (storage Storage) Version(ctx context.Context, url string) (string, error) {
+	backend, err := storage.findBackend(url, "", File)
+	if err != nil {
+		return "", err
 	}
 
-	return out, nil
+	v, ok := backend.(Versioner)
+	if !ok {
+		return "", ErrNoVersion
+	}
+	return v.Version(ctx, url)
+}
+
+// Stat returns information about the object (file) at the given "url".
+// If the object doesn't exist, ErrNotFound is returned.
+func (storage Storage) Stat(ctx context.Context, url string) (*Object, error) {
+	backend, err := storage.findBackend(url, "", File)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Stat(ctx, url)
+}
+
+// List returns all the objects (files) under the directory at the given "url".
+func (storage Storage) List(ctx context.Context, url string) ([]*Object, error) {
+	backend, err := storage.findBackend(url, "", Directory)
+	if err != nil {
+		return nil, err
+	}
+	return backend.List(ctx, url)
+}
+
+// Exists returns true if the object (file) at the given "url" exists.
+func (storage Storage) Exists(ctx context.Context, url string) (bool, error) {
+	_, err := storage.Stat(ctx, url)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 // Supports indicates whether the storage supports the given request.
@@ -108,6 +243,21 @@ func (storage Storage) Supports(url string, path string, class tes.FileType) boo
 	return b != nil
 }
 
+// SupportsPut indicates whether the storage supports uploading to the given url.
+func (storage Storage) SupportsPut(url string, path string, class tes.FileType) bool {
+	b, _ := storage.findBackend(url, path, class)
+	return b != nil && supportsPut(b, url, path, class)
+}
+
+// supportsPut returns true unless the backend implements PutSupporter
+// and doesn't support uploading to the given url.
+func supportsPut(b Backend, url string, path string, class tes.FileType) bool {
+	if p, ok := b.(PutSupporter); ok {
+		return p.SupportsPut(url, path, class)
+	}
+	return true
+}
+
 // findBackend tries to find a backend that matches the given url/path/class.
 // This is how a url gets matched to a backend, for example by the url prefix "s3://".
 func (storage Storage) findBackend(url string, path string, class tes.FileType) (Backend, error) {
@@ -125,7 +275,86 @@ func (storage Storage) WithBackend(b Backend) Storage {
 	return storage
 }
 
+// WithParallelTransfers returns a new child Storage instance which transfers
+// up to "n" files at once. The limit applies to all the concurrent Get and
+// Put calls of the instance and its children, including the files of
+// directory uploads, which are uploaded in parallel.
+func (storage Storage) WithParallelTransfers(n int) Storage {
+	storage.transfers = nil
+	if n > 0 {
+		storage.transfers = make(chan struct{}, n)
+	}
+	return storage
+}
+
+// acquire waits until a file may be transferred, see WithParallelTransfers.
+// The returned function must be called when the transfer is done.
+func (storage Storage) acquire(ctx context.Context) (func(), error) {
+	if storage.transfers == nil {
+		return func() {}, nil
+	}
+	select {
+	case storage.transfers <- struct{}{}:
+		return func() { <-storage.transfers }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// putFile uploads a single file with the given backend, within the limit
+// of parallel transfers. If "algorithm" isn't empty, the file's checksum
+// is computed by a second read of the file, running concurrently with
+// the upload, and returned.
+func (storage Storage) putFile(ctx context.Context, backend Backend, url string, path string, algorithm string) (string, error) {
+	release, err := storage.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	if algorithm == "" {
+		return "", backend.PutFile(ctx, url, path)
+	}
+
+	var sum string
+	var serr error
+	done := make(chan struct{})
+	go func() {
+		sum, serr = Checksum(path, algorithm)
+		close(done)
+	}()
+
+	err = backend.PutFile(ctx, url, path)
+	<-done
+	if err != nil {
+		return "", err
+	}
+	if serr != nil {
+		return "", fmt.Errorf("couldn't compute checksum of %s: %s", url, serr)
+	}
+	return sum, nil
+}
+
+// WithRetryNotify returns a new child Storage instance which calls "fn"
+// before each retry of a failed request, by the backends which retry
+// failed requests, see RetryBackend.
+func (storage Storage) WithRetryNotify(fn RetryNotify) Storage {
+	backends := make([]Backend, len(storage.backends))
+	for i, b := range storage.backends {
+		if rb, ok := b.(*RetryBackend); ok {
+			c := *rb
+			c.Notify = fn
+			b = &c
+		}
+		backends[i] = b
+	}
+	storage.backends = backends
+	return storage
+}
+
 // WithConfig returns a new Storage instance with the given additional configuration.
+// If conf.Retry allows retries, each configured backend is wrapped
+// in a RetryBackend.
 func (storage Storage) WithConfig(conf config.StorageConfig) (Storage, error) {
 
 	if conf.Local.Valid() {
@@ -133,7 +362,7 @@ func (storage Storage) WithConfig(conf config.StorageConfig) (Storage, error) {
 		if err != nil {
 			return storage, fmt.Errorf("failed to configure local storage backend: %s", err)
 		}
-		storage = storage.WithBackend(local)
+		storage = storage.WithBackend(withRetry(local, conf.Retry))
 	}
 
 	if conf.S3.Valid() {
@@ -141,7 +370,7 @@ func (storage Storage) WithConfig(conf config.StorageConfig) (Storage, error) {
 		if err != nil {
 			return storage, fmt.Errorf("failed to configure S3 storage backend: %s", err)
 		}
-		storage = storage.WithBackend(s3)
+		storage = storage.WithBackend(withRetry(s3, conf.Retry))
 	}
 
 	for _, c := range conf.GS {
@@ -150,7 +379,7 @@ func (storage Storage) WithConfig(conf config.StorageConfig) (Storage, error) {
 			if nerr != nil {
 				return storage, fmt.Errorf("failed to configure Google Storage backend: %s", nerr)
 			}
-			storage = storage.WithBackend(gs)
+			storage = storage.WithBackend(withRetry(gs, conf.Retry))
 		}
 	}
 
@@ -159,7 +388,15 @@ func (storage Storage) WithConfig(conf config.StorageConfig) (Storage, error) {
 		if err != nil {
 			return storage, fmt.Errorf("failed to config Swift storage backend: %s", err)
 		}
-		storage = storage.WithBackend(s)
+		storage = storage.WithBackend(withRetry(s, conf.Retry))
+	}
+
+	if conf.HTTP.Valid() {
+		h, err := NewHTTPBackend(conf.HTTP)
+		if err != nil {
+			return storage, fmt.Errorf("failed to configure HTTP storage backend: %s", err)
+		}
+		storage = storage.WithBackend(withRetry(h, conf.Retry))
 	}
 
 	return storage, nil
@@ -172,18 +409,23 @@ type hostfile struct {
 	abs string
 	// Size of the file in bytes
 	size int64
+	// Modification time of the file
+	modTime time.Time
 }
 
 func walkFiles(root string) ([]hostfile, error) {
 	var files []hostfile
 
 	err := filepath.Walk(root, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if !f.IsDir() {
 			rel, err := filepath.Rel(root, p)
 			if err != nil {
 				return err
 			}
-			files = append(files, hostfile{rel, p, f.Size()})
+			files = append(files, hostfile{rel, p, f.Size(), f.ModTime()})
 		}
 		return nil
 	})
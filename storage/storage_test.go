@@ -1,8 +1,16 @@
 package storage
 
 import (
+	"context"
+	"fmt"
 	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestStorageWithConfig(t *testing.T) {
@@ -14,6 +22,7 @@ func TestStorageWithConfig(t *testing.T) {
 		GS:    []config.GSStorage{},
 		S3:    config.S3Storage{Disabled: true},
 		Swift: config.SwiftStorage{Disabled: true},
+		HTTP:  config.HTTPStorage{Disabled: true},
 	}
 	s := Storage{}
 	sc, err := s.WithConfig(c)
@@ -42,6 +51,7 @@ func TestStorageWithConfig(t *testing.T) {
 			},
 		},
 		Swift: config.SwiftStorage{Disabled: true},
+		HTTP:  config.HTTPStorage{Disabled: true},
 	}
 	sc, err = s.WithConfig(c)
 	if err != nil {
@@ -51,3 +61,105 @@ func TestStorageWithConfig(t *testing.T) {
 		t.Fatal("unexpected number of Storage backends")
 	}
 }
+
+// Tests that directory uploads with parallel transfers upload every file
+// and return the output file logs in a stable order.
+func TestStoragePutDirectoryParallel(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-storage-parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	s := Storage{}.
+		WithBackend(&LocalBackend{allowedDirs: []string{tmp}}).
+		WithParallelTransfers(3)
+
+	in := path.Join(tmp, "in")
+	os.MkdirAll(in, os.ModePerm)
+	for i := 0; i < 10; i++ {
+		ioutil.WriteFile(path.Join(in, fmt.Sprintf("file-%d.txt", i)), []byte("foo"), os.ModePerm)
+	}
+
+	out := path.Join(tmp, "out")
+	logs, err := s.Put(ctx, "file://"+out, in, tes.FileType_DIRECTORY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 10 {
+		t.Fatalf("expected 10 output file logs, got %d", len(logs))
+	}
+
+	for i, l := range logs {
+		if l.Path != path.Join(in, fmt.Sprintf("file-%d.txt", i)) {
+			t.Errorf("unexpected output file log order: %d %s", i, l.Path)
+		}
+		b, err := ioutil.ReadFile(path.Join(out, fmt.Sprintf("file-%d.txt", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "foo" {
+			t.Fatal("Unexpected content")
+		}
+	}
+}
+
+// countingBackend records the maximum number of concurrent uploads.
+type countingBackend struct {
+	LocalBackend
+	mtx     sync.Mutex
+	running int
+	max     int
+}
+
+func (c *countingBackend) PutFile(ctx context.Context, url string, path string) error {
+	c.mtx.Lock()
+	c.running++
+	if c.running > c.max {
+		c.max = c.running
+	}
+	c.mtx.Unlock()
+
+	time.Sleep(time.Millisecond * 10)
+
+	c.mtx.Lock()
+	c.running--
+	c.mtx.Unlock()
+	return nil
+}
+
+// Tests that concurrent directory uploads share the limit of parallel
+// transfers, instead of each upload getting its own limit.
+func TestStorageParallelTransfersShared(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-storage-parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for i := 0; i < 5; i++ {
+		ioutil.WriteFile(path.Join(tmp, fmt.Sprintf("file-%d.txt", i)), []byte("foo"), os.ModePerm)
+	}
+
+	b := &countingBackend{LocalBackend: LocalBackend{allowedDirs: []string{tmp}}}
+	s := Storage{}.WithBackend(b).WithParallelTransfers(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.Put(ctx, fmt.Sprintf("file:///out/%d", i), tmp, tes.FileType_DIRECTORY)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if b.max > 2 {
+		t.Errorf("expected at most 2 concurrent uploads, got %d", b.max)
+	}
+}
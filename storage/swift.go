@@ -145,6 +145,72 @@ func (sw *SwiftBackend) PutFile(ctx context.Context, rawurl string, hostPath str
 	return writer.Close()
 }
 
+// Version returns the hash (ETag) of a Swift object.
+func (sw *SwiftBackend) Version(ctx context.Context, rawurl string) (string, error) {
+	url, perr := sw.parse(rawurl)
+	if perr != nil {
+		return "", perr
+	}
+
+	info, _, err := sw.conn.Object(url.bucket, url.path)
+	if err != nil {
+		return "", err
+	}
+	return info.Hash, nil
+}
+
+// Stat returns information about a Swift object.
+func (sw *SwiftBackend) Stat(ctx context.Context, rawurl string) (*Object, error) {
+	url, perr := sw.parse(rawurl)
+	if perr != nil {
+		return nil, perr
+	}
+
+	info, _, err := sw.conn.Object(url.bucket, url.path)
+	if err == swift.ObjectNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return swiftObject(url.bucket, info), nil
+}
+
+// List returns all the Swift objects under the given url prefix.
+func (sw *SwiftBackend) List(ctx context.Context, rawurl string) ([]*Object, error) {
+	url, perr := sw.parse(rawurl)
+	if perr != nil {
+		return nil, perr
+	}
+
+	objs, err := sw.conn.ObjectsAll(url.bucket, &swift.ObjectsOpts{
+		Prefix: strings.TrimSuffix(url.path, "/") + "/",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*Object
+	for _, obj := range objs {
+		// Skip directory markers, e.g. "dir/".
+		if obj.PseudoDirectory || strings.HasSuffix(obj.Name, "/") {
+			continue
+		}
+		objects = append(objects, swiftObject(url.bucket, obj))
+	}
+	return objects, nil
+}
+
+func swiftObject(bucket string, obj swift.Object) *Object {
+	return &Object{
+		URL:          swiftScheme + "://" + bucket + "/" + obj.Name,
+		Size:         obj.Bytes,
+		LastModified: obj.LastModified,
+		ETag:         obj.Hash,
+		MD5:          md5FromETag(obj.Hash),
+	}
+}
+
 func (sw *SwiftBackend) parse(rawurl string) (*urlparts, error) {
 	url, err := urllib.Parse(rawurl)
 	if err != nil {
@@ -2,10 +2,17 @@ package core
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"github.com/ohsu-comp-bio/funnel/tests"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -365,6 +372,98 @@ func TestCancelUnknownTask(t *testing.T) {
 	}
 }
 
+// Test pausing and resuming a running task. The executor is frozen while
+// the task is paused, so it doesn't write any output.
+func TestPauseResume(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`
+    --sh 'for i in 1 2 3 4 5; do echo $i; sleep 1; done'
+  `)
+	fun.WaitForRunning(id)
+	fun.WaitForExec(id, 1)
+
+	err := fun.Pause(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := fun.Get(id)
+	if task.State != tes.State_PAUSED {
+		t.Fatalf("Unexpected state: %s", task.State.String())
+	}
+
+	// Give the worker time to freeze the executor and flush its logs.
+	time.Sleep(time.Second * 3)
+	before := fun.Get(id).Logs[0].Logs[0].Stdout
+	time.Sleep(time.Second * 3)
+	after := fun.Get(id).Logs[0].Logs[0].Stdout
+	if before != after {
+		t.Fatalf("executor wrote output while paused: %q -> %q", before, after)
+	}
+
+	err = fun.Resume(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task = fun.Wait(id)
+	if task.State != tes.State_COMPLETE {
+		t.Fatalf("Unexpected state: %s", task.State.String())
+	}
+	if task.Logs[0].Logs[0].Stdout != "1\n2\n3\n4\n5\n" {
+		t.Fatalf("Unexpected stdout: %q", task.Logs[0].Logs[0].Stdout)
+	}
+}
+
+// Test that a canceled paused task is stopped.
+func TestCancelPaused(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`
+    --sh 'sleep 1000'
+  `)
+	fun.WaitForRunning(id)
+	fun.WaitForExec(id, 1)
+
+	err := fun.Pause(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second * 2)
+	fun.Cancel(id)
+	fun.WaitForDockerDestroy(id + "-0")
+	task := fun.Get(id)
+	if task.State != tes.State_CANCELED {
+		t.Fatalf("Unexpected state: %s", task.State.String())
+	}
+}
+
+// Test that only running tasks can be paused and only paused tasks resumed.
+func TestPauseResumeInvalidState(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`
+    --sh 'sleep 1000'
+  `)
+	fun.WaitForRunning(id)
+
+	_, err := fun.HTTP.ResumeTask(context.Background(), &control.ResumeTaskRequest{Id: id})
+	if err == nil {
+		t.Fatal("expected error resuming a running task")
+	}
+
+	fun.Cancel(id)
+	fun.Wait(id)
+	err = fun.Pause(id)
+	s, _ := status.FromError(err)
+	if err == nil || s.Code() != codes.FailedPrecondition {
+		t.Fatal("expected failed precondition error pausing a canceled task", err)
+	}
+
+	_, err = fun.HTTP.PauseTask(context.Background(), &control.PauseTaskRequest{
+		Id: "nonexistent-task-id",
+	})
+	if err == nil || !strings.Contains(err.Error(), "STATUS CODE - 404") {
+		t.Fatal("expected not found error", err)
+	}
+}
+
 // The task executor logs list should only include entries for steps that
 // have been started or completed, i.e. steps that have yet to be started
 // won't show up in Task.Logs[0].Logs
@@ -416,6 +515,58 @@ func TestTaskStartEndTimeLogs(t *testing.T) {
 	}
 }
 
+func TestExecutorResourceUsage(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	// Keep a CPU busy for a few seconds, so that the docker runtime gets
+	// a few samples of the container stats.
+	id := fun.Run(`--sh 'end=$(($(date +%s)+3)); while [ $(date +%s) -lt $end ]; do :; done'`)
+	fun.Wait(id)
+	// Some databases require more time to process the updates.
+	time.Sleep(time.Millisecond * 500)
+	task := fun.Get(id)
+
+	v, ok := task.Logs[0].Metadata["executor_0_resource_usage"]
+	if !ok {
+		t.Fatalf("missing executor resource usage: %#v", task.Logs[0].Metadata)
+	}
+	usage := events.ResourceUsage{}
+	err := json.Unmarshal([]byte(v), &usage)
+	if err != nil {
+		t.Fatal("failed to decode resource usage", err)
+	}
+	if usage.CpuAvg <= 0 {
+		t.Error("expected non-zero average CPU usage", usage)
+	}
+	if usage.CpuPeak > 0 && usage.CpuPeak < usage.CpuAvg {
+		t.Error("expected the peak CPU usage to be at least the average", usage)
+	}
+	if usage.MemPeakBytes == 0 {
+		t.Error("expected non-zero peak memory usage", usage)
+	}
+}
+
+func TestExecutorImageDigest(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`--sh 'echo 1'`)
+	fun.Wait(id)
+	// Some databases require more time to process the updates.
+	time.Sleep(time.Millisecond * 500)
+	task := fun.Get(id)
+
+	meta := task.Logs[0].Metadata
+	v, ok := meta["executor_0_image_digest"]
+	if !ok {
+		t.Fatalf("missing executor image digest: %#v", meta)
+	}
+	if !strings.Contains(v, "@sha256:") {
+		t.Fatal("unexpected image digest", v)
+	}
+	// Other metadata is kept.
+	if _, ok := meta["executor_0_resource_usage"]; !ok {
+		t.Fatalf("missing executor resource usage: %#v", meta)
+	}
+}
+
 func TestOutputFileLog(t *testing.T) {
 	tests.SetLogOutput(log, t)
 	dir := fun.Tempdir()
@@ -469,6 +620,460 @@ func TestOutputFileLog(t *testing.T) {
 	}
 }
 
+func TestOutputGlob(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	dir := fun.Tempdir()
+
+	id, _ := fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image: "alpine",
+				Command: []string{
+					"sh", "-c", "mkdir -p /tmp/out/a; echo fooo > /tmp/out/a/one.txt; echo ba > /tmp/out/a/two.txt; echo bar > /tmp/out/a/skip.log",
+				},
+			},
+		},
+		Outputs: []*tes.Output{
+			{
+				Url:  dir + "/results",
+				Path: "/tmp/out/*/*.txt",
+			},
+		},
+	})
+
+	task := fun.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("expected complete state", task.State)
+	}
+
+	out := task.Logs[0].Outputs
+	if len(out) != 2 {
+		t.Fatal("expected 2 output file logs", out)
+	}
+	if out[0].Url != dir+"/results/a/one.txt" || out[0].Path != "/tmp/out/a/one.txt" || out[0].SizeBytes != 5 {
+		t.Fatal("unexpected output", out[0])
+	}
+	if out[1].Url != dir+"/results/a/two.txt" || out[1].Path != "/tmp/out/a/two.txt" || out[1].SizeBytes != 3 {
+		t.Fatal("unexpected output", out[1])
+	}
+	if _, err := os.Stat(dir + "/results/a/skip.log"); err == nil {
+		t.Fatal("unexpected upload of unmatched file")
+	}
+}
+
+func TestOutputGlobNoMatch(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	dir := fun.Tempdir()
+
+	id, _ := fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"echo", "hello"},
+			},
+		},
+		Outputs: []*tes.Output{
+			{
+				Url:  dir + "/results",
+				Path: "/tmp/out/*.txt",
+			},
+		},
+	})
+
+	task := fun.Wait(id)
+
+	if task.State != tes.State_SYSTEM_ERROR {
+		t.Fatal("expected system error state", task.State)
+	}
+}
+
+func TestOptionalOutputs(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	dir := fun.Tempdir()
+
+	id, _ := fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"sh", "-c", "echo hello > /tmp/out.txt"},
+			},
+		},
+		Outputs: []*tes.Output{
+			{
+				Url:  dir + "/out.txt",
+				Path: "/tmp/out.txt",
+			},
+			{
+				Url:  dir + "/missing.txt",
+				Path: "/tmp/missing.txt",
+			},
+			{
+				Url:  dir + "/results",
+				Path: "/tmp/results/*.txt",
+			},
+		},
+		Tags: map[string]string{
+			"funnel_optional_outputs": "/tmp/missing.txt,/tmp/results/*.txt",
+		},
+	})
+
+	task := fun.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	out := task.Logs[0].Outputs
+	if len(out) != 1 || out[0].Path != "/tmp/out.txt" {
+		t.Fatal("unexpected outputs", out)
+	}
+}
+
+func TestUploadOutputsOnFailure(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	dir := fun.Tempdir()
+
+	id, _ := fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"sh", "-c", "echo partial > /tmp/debug.log; exit 1"},
+			},
+		},
+		Outputs: []*tes.Output{
+			{
+				Url:  dir + "/debug.log",
+				Path: "/tmp/debug.log",
+			},
+			{
+				Url:  dir + "/results.txt",
+				Path: "/tmp/results.txt",
+			},
+		},
+		Tags: map[string]string{
+			"funnel_upload_on_failure": "/tmp/debug.log",
+		},
+	})
+
+	task := fun.Wait(id)
+
+	if task.State != tes.State_EXECUTOR_ERROR {
+		t.Fatal("unexpected state", task.State)
+	}
+	b, err := ioutil.ReadFile(dir + "/debug.log")
+	if err != nil || string(b) != "partial\n" {
+		t.Fatal("expected the debug log to be uploaded", string(b), err)
+	}
+	out := task.Logs[0].Outputs
+	if len(out) != 1 || out[0].Path != "/tmp/debug.log" {
+		t.Fatal("unexpected outputs", out)
+	}
+}
+
+// A failed upload of a failed task's outputs doesn't stop the others,
+// and the outputs which were uploaded are still reported.
+func TestUploadOutputsOnFailureError(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	dir := fun.Tempdir()
+
+	id, _ := fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"sh", "-c", "echo partial > /tmp/debug.log; echo stats > /tmp/stats.txt; exit 1"},
+			},
+		},
+		Outputs: []*tes.Output{
+			{
+				// Not in the allowed directories, so the upload fails.
+				Url:  "file:///funnel-not-allowed/debug.log",
+				Path: "/tmp/debug.log",
+			},
+			{
+				Url:  dir + "/stats.txt",
+				Path: "/tmp/stats.txt",
+			},
+		},
+		Tags: map[string]string{
+			"funnel_upload_on_failure": "true",
+		},
+	})
+
+	task := fun.Wait(id)
+
+	if task.State != tes.State_EXECUTOR_ERROR {
+		t.Fatal("unexpected state", task.State)
+	}
+	b, err := ioutil.ReadFile(dir + "/stats.txt")
+	if err != nil || string(b) != "stats\n" {
+		t.Fatal("expected the stats to be uploaded", string(b), err)
+	}
+	out := task.Logs[0].Outputs
+	if len(out) != 1 || out[0].Path != "/tmp/stats.txt" {
+		t.Fatal("unexpected outputs", out)
+	}
+}
+
+func TestTaskContextEnv(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`
+    --sh 'echo $FUNNEL_TASK_ID $FUNNEL_EXECUTOR_INDEX $FUNNEL_ATTEMPT $FUNNEL_CPUS'
+    --sh 'echo $FUNNEL_EXECUTOR_INDEX'
+    --cpu 1
+  `)
+	task := fun.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	if out := task.Logs[0].Logs[0].Stdout; out != id+" 0 0 1\n" {
+		t.Fatal("unexpected env in first executor", out)
+	}
+	if out := task.Logs[0].Logs[1].Stdout; out != "1\n" {
+		t.Fatal("unexpected env in second executor", out)
+	}
+}
+
+func TestOutputURLPlaceholders(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	dir := fun.Tempdir()
+
+	id, _ := fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"sh", "-c", "echo hello > /tmp/out.txt"},
+			},
+		},
+		Outputs: []*tes.Output{
+			{
+				Url:  dir + "/{task_id}/{attempt}/out.txt",
+				Path: "/tmp/out.txt",
+			},
+		},
+	})
+	task := fun.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	expected := dir + "/" + id + "/0/out.txt"
+	if _, err := os.Stat(expected); err != nil {
+		t.Fatal("expected output to be uploaded to the expanded URL", err)
+	}
+	if task.Logs[0].Outputs[0].Url != expected {
+		t.Fatal("unexpected output URL", task.Logs[0].Outputs[0].Url)
+	}
+}
+
+func TestInputChecksum(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	dir := fun.Tempdir()
+	ioutil.WriteFile(dir+"/in.txt", []byte("test"), os.ModePerm)
+
+	task := &tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"cat", "/tmp/in.txt"},
+			},
+		},
+		Inputs: []*tes.Input{
+			{
+				Url:  dir + "/in.txt",
+				Path: "/tmp/in.txt",
+			},
+		},
+		Tags: map[string]string{
+			"funnel_input_checksum_0": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		},
+	}
+
+	id, _ := fun.RunTask(task)
+	if s := fun.Wait(id).State; s != tes.State_COMPLETE {
+		t.Fatal("unexpected state", s)
+	}
+
+	task.Tags["funnel_input_checksum_0"] = "md5:d41d8cd98f00b204e9800998ecf8427e"
+	id, _ = fun.RunTask(task)
+	if s := fun.Wait(id).State; s != tes.State_SYSTEM_ERROR {
+		t.Fatal("expected checksum mismatch to fail the task, got", s)
+	}
+}
+
+func TestOutputChecksum(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	dir := fun.Tempdir()
+
+	id, _ := fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"sh", "-c", "printf test > /tmp/out.txt"},
+			},
+		},
+		Outputs: []*tes.Output{
+			{
+				Url:  dir + "/out.txt",
+				Path: "/tmp/out.txt",
+			},
+		},
+		Tags: map[string]string{
+			"funnel_output_checksum": "md5",
+		},
+	})
+	task := fun.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	sums := map[string]string{}
+	err := json.Unmarshal([]byte(task.Logs[0].Metadata["output_checksums"]), &sums)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if sum := sums[dir+"/out.txt"]; sum != "md5:098f6bcd4621d373cade4e832627b4f6" {
+		t.Fatal("unexpected output checksum", sums)
+	}
+}
+
+func TestExecutorContinueOnError(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`
+    --sh "sh -c 'exit 3'"
+    --sh 'echo report'
+    --tag funnel_executor_continue_on_error_0=true
+  `)
+	task := fun.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	if code := task.Logs[0].Logs[0].ExitCode; code != 3 {
+		t.Fatal("expected the exit code of the failed executor", code)
+	}
+	if out := task.Logs[0].Logs[1].Stdout; out != "report\n" {
+		t.Fatal("expected the next executor to run", out)
+	}
+}
+
+// Tests that an executor which times out fails the task, even if it may
+// continue on error.
+func TestExecutorContinueOnErrorTimeout(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`
+    --sh 'sleep 60'
+    --sh 'echo report'
+    --tag funnel_executor_continue_on_error_0=true
+    --tag funnel_executor_timeout_0=1s
+  `)
+	task := fun.Wait(id)
+
+	if task.State != tes.State_EXECUTOR_ERROR {
+		t.Fatal("unexpected state", task.State)
+	}
+	if len(task.Logs[0].Logs) != 1 {
+		t.Fatal("expected the next executor not to run", task.Logs[0].Logs)
+	}
+}
+
+func TestBackgroundExecutor(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	start := time.Now()
+	id := fun.Run(`
+    --sh 'sleep 60'
+    --sh 'echo hello'
+    --tag funnel_executor_background_0=true
+  `)
+	task := fun.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	if time.Since(start) > time.Second*50 {
+		t.Fatal("expected the background executor to be stopped")
+	}
+	if out := task.Logs[0].Logs[1].Stdout; out != "hello\n" {
+		t.Fatal("unexpected stdout", out)
+	}
+	bg := task.Logs[0].Logs[0]
+	if bg.EndTime == "" || bg.ExitCode == 0 {
+		t.Fatal("expected the exit code of the stopped background executor", bg)
+	}
+}
+
+func TestRunAsHostUser(t *testing.T) {
+	tests.SetLogOutput(log, t)
+
+	id, _ := fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"id", "-u"},
+			},
+		},
+		Tags: map[string]string{
+			"funnel_run_as_host_user": "true",
+		},
+	})
+	task := fun.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	uid := strings.TrimSpace(task.Logs[0].Logs[0].Stdout)
+	if uid != fmt.Sprint(os.Getuid()) {
+		t.Fatal("unexpected container user", uid)
+	}
+}
+
+func TestNetworkNone(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "local"
+	c.Worker.AllowedNetworkModes = []string{"none"}
+	f := tests.NewFunnel(c)
+	f.StartServer()
+
+	id, _ := f.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"ls", "/sys/class/net"},
+			},
+		},
+		Tags: map[string]string{
+			"funnel_network": "none",
+		},
+	})
+	task := f.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	if ifaces := strings.Fields(task.Logs[0].Logs[0].Stdout); len(ifaces) != 1 || ifaces[0] != "lo" {
+		t.Fatal("expected only a loopback interface", ifaces)
+	}
+
+	// Tasks can't select a network mode by default.
+	id, _ = fun.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"echo", "hello"},
+			},
+		},
+		Tags: map[string]string{
+			"funnel_network": "none",
+		},
+	})
+	task = fun.Wait(id)
+
+	if task.State != tes.State_SYSTEM_ERROR {
+		t.Fatal("unexpected state", task.State)
+	}
+}
+
 func TestPagination(t *testing.T) {
 	tests.SetLogOutput(log, t)
 	c := tests.DefaultConfig()
@@ -600,6 +1205,46 @@ func TestSmallPagination(t *testing.T) {
 	}
 }
 
+// Test that the server rejects a task with a missing input when the input
+// preflight is enabled, unless the task skips the preflight.
+func TestInputPreflight(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "noop"
+	dir, _ := filepath.Abs(c.Worker.Storage.Local.AllowedDirs[0])
+	c.Server.PreflightInputs = true
+	c.Server.PreflightURLPrefixes = []string{dir}
+	f := tests.NewFunnel(c)
+	f.StartServer()
+	ctx := context.Background()
+
+	f.WriteFile("preflight_in", "content")
+	task := &tes.Task{
+		Executors: []*tes.Executor{
+			{Image: "alpine", Command: []string{"echo"}},
+		},
+		Inputs: []*tes.Input{
+			{Url: dir + "/preflight_in", Path: "/in"},
+			{Url: dir + "/preflight_typo", Path: "/typo"},
+		},
+	}
+
+	_, err := f.RPC.CreateTask(ctx, task)
+	s, _ := status.FromError(err)
+	if err == nil || s.Code() != codes.InvalidArgument {
+		t.Fatal("expected invalid argument error", err)
+	}
+	if !strings.Contains(s.Message(), "Task.Inputs[1].Url: file not found") {
+		t.Error("unexpected error message", s.Message())
+	}
+
+	task.Tags = map[string]string{"funnel_skip_input_preflight": "true"}
+	_, err = f.RPC.CreateTask(ctx, task)
+	if err != nil {
+		t.Fatal("expected preflight to be skipped", err)
+	}
+}
+
 func TestTaskError(t *testing.T) {
 	tests.SetLogOutput(log, t)
 	id := fun.Run(`
@@ -612,6 +1257,32 @@ func TestTaskError(t *testing.T) {
 	}
 }
 
+func TestExecutorTimeout(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`
+    --sh "sleep 60"
+    --tag funnel_executor_timeout=1s
+  `)
+	task := fun.Wait(id)
+
+	if task.State != tes.State_EXECUTOR_ERROR {
+		t.Fatal("unexpected task state", task.State)
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	id := fun.Run(`
+    --sh "sleep 60"
+    --tag funnel_timeout=1s
+  `)
+	task := fun.Wait(id)
+
+	if task.State != tes.State_SYSTEM_ERROR {
+		t.Fatal("unexpected task state", task.State)
+	}
+}
+
 func TestLargeLogTail(t *testing.T) {
 	tests.SetLogOutput(log, t)
 	// Generate lots of random data to stdout.
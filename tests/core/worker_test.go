@@ -2,13 +2,18 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	workerCmd "github.com/ohsu-comp-bio/funnel/cmd/worker"
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"github.com/ohsu-comp-bio/funnel/storage"
 	"github.com/ohsu-comp-bio/funnel/tests"
 	"github.com/ohsu-comp-bio/funnel/worker"
+	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -86,6 +91,334 @@ func TestDefaultWorkerRun(t *testing.T) {
 	}
 }
 
+func TestRetrySystemError(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "local"
+	c.Worker.Retry.MaxAttempts = 3
+	c.Worker.Retry.Backoff = time.Millisecond * 10
+	f := tests.NewFunnel(c)
+	f.StartServer()
+
+	// The input doesn't exist, so every attempt fails with a system error.
+	id := f.Run(`
+    --sh 'echo hello world'
+    --in in={{ .storage }}/missing.txt
+  `)
+	task := f.Wait(id)
+
+	if task.State != tes.State_SYSTEM_ERROR {
+		t.Fatal("unexpected state", task.State)
+	}
+
+	if len(task.Logs) != 3 {
+		t.Fatal("expected a task log per attempt", len(task.Logs))
+	}
+	for i, tl := range task.Logs {
+		if tl.StartTime == "" || tl.EndTime == "" {
+			t.Error("missing start/end time for attempt", i)
+		}
+	}
+}
+
+func TestTaskLogHostAndStageMetadata(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "noop"
+	f := tests.NewFunnel(c)
+	f.StartServer()
+
+	// this only writes the task to the DB since the 'noop'
+	// compute backend is in use
+	id := f.Run(`
+    --sh 'echo hello world'
+  `)
+
+	err := workerCmd.Run(c.Worker, id, log)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	task, err := f.HTTP.GetTask(context.Background(), &tes.GetTaskRequest{
+		Id:   id,
+		View: tes.TaskView_BASIC,
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	meta := task.Logs[0].Metadata
+	for _, k := range []string{
+		"hostname",
+		"funnel_version",
+		"download_start_time",
+		"download_end_time",
+		"execute_start_time",
+		"execute_end_time",
+		"upload_start_time",
+		"upload_end_time",
+	} {
+		if meta[k] == "" {
+			t.Error("missing metadata", k)
+		}
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, meta["execute_start_time"])
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, meta["execute_end_time"])
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if end.Before(start) {
+		t.Error("expected execute stage to end after it starts")
+	}
+}
+
+// startLostTask starts a task, as if a worker had started it,
+// with a last heartbeat from an hour ago.
+func startLostTask(t *testing.T, f *tests.Funnel) string {
+	// this only writes the task to the DB since the 'noop'
+	// compute backend is in use
+	id := f.Run(`
+    --sh 'echo hello world'
+  `)
+
+	ctx := context.Background()
+	hb := events.NewHeartbeat(id, 0)
+	hb.Timestamp = time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
+	for _, ev := range []*events.Event{
+		events.NewState(id, 0, tes.State_INITIALIZING),
+		events.NewState(id, 0, tes.State_RUNNING),
+		hb,
+	} {
+		if _, err := f.Srv.DB.CreateEvent(ctx, ev); err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+	return id
+}
+
+func TestLostWorker(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "noop"
+	c.Server.HeartbeatTimeout = time.Minute
+	f := tests.NewFunnel(c)
+	f.StartServer()
+
+	id := startLostTask(t, f)
+
+	// Other system logs aren't stored.
+	syslog := events.NewSystemLog(id, 0, 0, "info", "hello", nil)
+	_, err := f.Srv.DB.CreateEvent(context.Background(), syslog)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	err = f.Srv.Monitor.CheckTasks(context.Background())
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	task, err := f.HTTP.GetTask(context.Background(), &tes.GetTaskRequest{
+		Id:   id,
+		View: tes.TaskView_FULL,
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	if task.State != tes.State_SYSTEM_ERROR {
+		t.Fatal("unexpected state", task.State)
+	}
+	if len(task.Logs[0].SystemLogs) != 1 ||
+		!strings.Contains(task.Logs[0].SystemLogs[0], "heartbeat") {
+		t.Error("expected a system log explaining the lost worker", task.Logs[0].SystemLogs)
+	}
+}
+
+func TestLostWorkerRetry(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "noop"
+	c.Server.HeartbeatTimeout = time.Minute
+	c.Worker.Retry.MaxAttempts = 2
+	f := tests.NewFunnel(c)
+	f.StartServer()
+
+	id := startLostTask(t, f)
+
+	err := f.Srv.Monitor.CheckTasks(context.Background())
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	task, err := f.HTTP.GetTask(context.Background(), &tes.GetTaskRequest{
+		Id:   id,
+		View: tes.TaskView_BASIC,
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	if task.State != tes.State_QUEUED {
+		t.Fatal("unexpected state", task.State)
+	}
+	if len(task.Logs) != 2 {
+		t.Fatal("expected a task log for the next attempt", len(task.Logs))
+	}
+}
+
+func TestWorkerHeartbeat(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "noop"
+	c.Worker.HeartbeatRate = time.Millisecond * 100
+	f := tests.NewFunnel(c)
+	f.StartServer()
+
+	// this only writes the task to the DB since the 'noop'
+	// compute backend is in use
+	id := f.Run(`
+    --sh 'sleep 1'
+  `)
+
+	err := workerCmd.Run(c.Worker, id, log)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	task, err := f.HTTP.GetTask(context.Background(), &tes.GetTaskRequest{
+		Id:   id,
+		View: tes.TaskView_BASIC,
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	if _, ok := events.LastHeartbeat(task); !ok {
+		t.Fatal("expected a worker heartbeat", task.Logs[0].Metadata)
+	}
+}
+
+func TestExecutorLogsArchive(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "local"
+	// Only a short tail of the logs is kept in the task logs.
+	c.Worker.BufferSize = 10
+	dir, _ := filepath.Abs(c.Worker.Storage.Local.AllowedDirs[0])
+	dir += "/logs"
+	c.Worker.ExecutorLogsURL = dir
+	f := tests.NewFunnel(c)
+	f.StartServer()
+
+	id := f.Run(`
+    --sh 'seq 1 1000; echo error >&2'
+  `)
+	task := f.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+
+	meta := task.Logs[0].Metadata
+	stdoutURL := meta["executor_0_stdout_url"]
+	stderrURL := meta["executor_0_stderr_url"]
+	if stdoutURL != dir+"/"+id+"/0/executor-0.stdout" {
+		t.Fatal("unexpected stdout url", stdoutURL)
+	}
+	if stderrURL != dir+"/"+id+"/0/executor-0.stderr" {
+		t.Fatal("unexpected stderr url", stderrURL)
+	}
+
+	b, err := ioutil.ReadFile(stdoutURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "1\n2\n3\n") || !strings.HasSuffix(string(b), "999\n1000\n") {
+		t.Fatal("incomplete stdout archive")
+	}
+
+	b, err = ioutil.ReadFile(stderrURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "error\n" {
+		t.Fatal("unexpected stderr archive", string(b))
+	}
+}
+
+func TestExecutorEnvSecret(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "local"
+	dir, _ := filepath.Abs(c.Worker.Storage.Local.AllowedDirs[0])
+	c.Worker.Secrets.Provider = "file"
+	c.Worker.Secrets.File.Dir = dir + "/secrets"
+	f := tests.NewFunnel(c)
+	f.StartServer()
+	os.MkdirAll(dir+"/secrets", 0700)
+	f.WriteFile("secrets/db-password", "hunter2\n")
+
+	id, err := f.RunTask(&tes.Task{
+		Executors: []*tes.Executor{
+			{
+				Image:   "alpine",
+				Command: []string{"sh", "-c", "echo $DB_PASSWORD > /tmp/out.txt"},
+				Env:     map[string]string{"DB_PASSWORD": "secret://db-password"},
+			},
+		},
+		Outputs: []*tes.Output{
+			{
+				Url:  dir + "/out.txt",
+				Path: "/tmp/out.txt",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := f.Wait(id)
+
+	if task.State != tes.State_COMPLETE {
+		t.Fatal("unexpected state", task.State)
+	}
+	if out := f.ReadFile("out.txt"); out != "hunter2\n" {
+		t.Fatal("unexpected secret value in executor", out)
+	}
+
+	task = f.Get(id)
+	b, _ := json.Marshal(task)
+	if strings.Contains(string(b), "hunter2") {
+		t.Fatal("secret value leaked into the task")
+	}
+	if task.Executors[0].Env["DB_PASSWORD"] != "secret://db-password" {
+		t.Fatal("expected the secret reference in the task")
+	}
+}
+
+func TestExecutorEnvSecretMissing(t *testing.T) {
+	tests.SetLogOutput(log, t)
+	c := tests.DefaultConfig()
+	c.Backend = "local"
+	f := tests.NewFunnel(c)
+	f.StartServer()
+
+	// No secret provider is configured.
+	id := f.Run(`
+    --sh 'echo $DB_PASSWORD'
+    --env DB_PASSWORD=secret://db-password
+  `)
+	task := f.Wait(id)
+
+	if task.State != tes.State_SYSTEM_ERROR {
+		t.Fatal("unexpected state", task.State)
+	}
+}
+
 type eventCounter struct {
 	stdout, stderr int
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/proto/control"
 	pbs "github.com/ohsu-comp-bio/funnel/proto/scheduler"
 	"github.com/ohsu-comp-bio/funnel/proto/tes"
 	"github.com/ohsu-comp-bio/funnel/server"
@@ -41,9 +42,10 @@ func init() {
 // Funnel provides a test server and RPC/HTTP clients
 type Funnel struct {
 	// Clients
-	RPC    tes.TaskServiceClient
-	HTTP   *client.Client
-	Docker *docker.Client
+	RPC     tes.TaskServiceClient
+	Control control.ControlServiceClient
+	HTTP    *client.Client
+	Docker  *docker.Client
 
 	// Config
 	Conf       config.Config
@@ -100,6 +102,7 @@ func (f *Funnel) AddRPCClient(opts ...grpc.DialOption) error {
 	}
 	f.conn = conn
 	f.RPC = tes.NewTaskServiceClient(conn)
+	f.Control = control.NewControlServiceClient(conn)
 	return nil
 }
 
@@ -187,6 +190,22 @@ func (f *Funnel) Cancel(id string) error {
 	return err
 }
 
+// Pause pauses a task by ID
+func (f *Funnel) Pause(id string) error {
+	_, err := f.Control.PauseTask(context.Background(), &control.PauseTaskRequest{
+		Id: id,
+	})
+	return err
+}
+
+// Resume resumes a paused task by ID
+func (f *Funnel) Resume(id string) error {
+	_, err := f.Control.ResumeTask(context.Background(), &control.ResumeTaskRequest{
+		Id: id,
+	})
+	return err
+}
+
 // ListView returns a task list (calls ListTasks) with the given view.
 func (f *Funnel) ListView(view tes.TaskView) []*tes.Task {
 	t, err := f.RPC.ListTasks(context.Background(), &tes.ListTasksRequest{
@@ -262,7 +281,7 @@ func (f *Funnel) Wait(id string) *tes.Task {
 	for range time.NewTicker(f.rate).C {
 		t := f.Get(id)
 		if t.State != tes.State_QUEUED && t.State != tes.State_INITIALIZING &&
-			t.State != tes.State_RUNNING {
+			t.State != tes.State_RUNNING && t.State != tes.State_PAUSED {
 			return t
 		}
 	}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"context"
+	"sync"
+)
+
+// ParallelDo calls "fn" for each index in [0, n), with at most "limit" calls
+// running at once.
+//
+// ParallelDo fails fast: the first error cancels the context passed to the
+// running calls, no new calls are started, and the error is returned once
+// the running calls have returned.
+func ParallelDo(ctx context.Context, n, limit int, fn func(ctx context.Context, i int) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	subctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	sem := make(chan struct{}, limit)
+
+loop:
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-subctx.Done():
+			break loop
+		}
+		if subctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(subctx, i); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if first != nil {
+		return first
+	}
+	return ctx.Err()
+}
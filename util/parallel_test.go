@@ -0,0 +1,82 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallelDo(t *testing.T) {
+	var mtx sync.Mutex
+	running := 0
+	maxRunning := 0
+	done := make([]bool, 20)
+
+	err := ParallelDo(context.Background(), 20, 3, func(ctx context.Context, i int) error {
+		mtx.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mtx.Unlock()
+
+		time.Sleep(time.Millisecond * 10)
+
+		mtx.Lock()
+		running--
+		done[i] = true
+		mtx.Unlock()
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if maxRunning > 3 {
+		t.Errorf("expected at most 3 calls at once, got %d", maxRunning)
+	}
+	for i, d := range done {
+		if !d {
+			t.Errorf("call %d didn't run", i)
+		}
+	}
+}
+
+func TestParallelDoFailFast(t *testing.T) {
+	expected := errors.New("fail")
+	var mtx sync.Mutex
+	started := 0
+	canceled := false
+
+	err := ParallelDo(context.Background(), 100, 2, func(ctx context.Context, i int) error {
+		mtx.Lock()
+		started++
+		mtx.Unlock()
+
+		if i == 0 {
+			return expected
+		}
+
+		select {
+		case <-ctx.Done():
+			mtx.Lock()
+			canceled = true
+			mtx.Unlock()
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+
+	if err != expected {
+		t.Fatal("expected the first error, got", err)
+	}
+	if !canceled {
+		t.Error("expected in-flight calls to be canceled")
+	}
+	if started > 3 {
+		t.Errorf("expected no new calls after the error, but %d started", started)
+	}
+}
@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/storage"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Task tags which control checksums.
+const (
+	// inputChecksumTagPrefix is the prefix of the tags which give the
+	// expected checksum of an input file, by index, as "<algorithm>:<hex>",
+	// e.g. "funnel_input_checksum_0": "sha256:9f86d08...".
+	inputChecksumTagPrefix = "funnel_input_checksum_"
+	// outputChecksumTag overrides the checksum algorithm of the outputs,
+	// see config.Worker.OutputChecksum.
+	outputChecksumTag = "funnel_output_checksum"
+)
+
+// checksum is the expected checksum of a file.
+type checksum struct {
+	algorithm string
+	value     string
+}
+
+// checksumPolicy holds the checksum options of a task.
+type checksumPolicy struct {
+	// inputs are the expected checksums of the inputs, by container path.
+	inputs map[string]checksum
+	// output is the checksum algorithm of the outputs, or empty.
+	output string
+}
+
+// getChecksumPolicy gets the checksum options from the task's tags,
+// falling back to the defaults in the worker config.
+func getChecksumPolicy(task *tes.Task, conf config.Worker) (checksumPolicy, error) {
+	p := checksumPolicy{
+		inputs: map[string]checksum{},
+		output: conf.OutputChecksum,
+	}
+
+	if v, ok := task.GetTags()[outputChecksumTag]; ok {
+		p.output = v
+	}
+	if p.output != "" && !storage.ValidChecksum(p.output) {
+		return p, fmt.Errorf("invalid output checksum algorithm %q: must be md5 or sha256", p.output)
+	}
+
+	for key, v := range task.GetTags() {
+		if !strings.HasPrefix(key, inputChecksumTagPrefix) {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimPrefix(key, inputChecksumTagPrefix))
+		if err != nil || i < 0 || i >= len(task.GetInputs()) {
+			return p, fmt.Errorf("invalid %s tag: no input at this index", key)
+		}
+		input := task.Inputs[i]
+		if input.Url == "" || input.Type != tes.FileType_FILE {
+			return p, fmt.Errorf("invalid %s tag: checksums are only supported for file inputs with a URL", key)
+		}
+		c, err := parseChecksum(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid %s tag %q: %s", key, v, err)
+		}
+		p.inputs[path.Clean(input.Path)] = c
+	}
+	return p, nil
+}
+
+// parseChecksum parses a checksum in the form "<algorithm>:<hex>",
+// e.g. "md5:d41d8cd98f00b204e9800998ecf8427e".
+func parseChecksum(s string) (checksum, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return checksum{}, fmt.Errorf("expected <algorithm>:<checksum>")
+	}
+	c := checksum{
+		algorithm: strings.ToLower(parts[0]),
+		value:     strings.ToLower(parts[1]),
+	}
+	if !storage.ValidChecksum(c.algorithm) {
+		return c, fmt.Errorf("unknown algorithm %q: must be md5 or sha256", c.algorithm)
+	}
+	return c, nil
+}
+
+// verifyInput checks that the downloaded input at the host path "p" has
+// the expected checksum. The downloaded file is always read, since it's
+// what the executors use.
+//
+// If the storage system provides an MD5 of the object, e.g. the S3 ETag
+// or the GS md5Hash, which doesn't match the expected MD5, the input fails
+// without reading the file.
+func (r *DefaultWorker) verifyInput(ctx context.Context, url string, p string, expected checksum) error {
+	if expected.algorithm == storage.MD5 {
+		obj, err := r.Store.Stat(ctx, url)
+		if err == nil && obj.MD5 != "" && obj.MD5 != expected.value {
+			return fmt.Errorf("checksum mismatch for input %s: expected %s:%s, storage reports %s:%s",
+				url, expected.algorithm, expected.value, expected.algorithm, obj.MD5)
+		}
+	}
+
+	actual, err := storage.Checksum(p, expected.algorithm)
+	if err != nil {
+		return fmt.Errorf("couldn't compute checksum of input %s: %s", url, err)
+	}
+	if actual != expected.value {
+		return fmt.Errorf("checksum mismatch for input %s: expected %s:%s, got %s:%s",
+			url, expected.algorithm, expected.value, expected.algorithm, actual)
+	}
+	return nil
+}
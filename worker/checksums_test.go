@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"context"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/storage"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testSHA256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func checksumsTask(tags map[string]string) *tes.Task {
+	return &tes.Task{
+		Inputs: []*tes.Input{
+			{Url: "file:///data/in.txt", Path: "/inputs/in.txt"},
+			{Url: "file:///data/dir", Path: "/inputs/dir", Type: tes.FileType_DIRECTORY},
+		},
+		Tags: tags,
+	}
+}
+
+func TestChecksumPolicy(t *testing.T) {
+	p, err := getChecksumPolicy(checksumsTask(nil), config.Worker{OutputChecksum: "md5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.output != "md5" || len(p.inputs) != 0 {
+		t.Error("unexpected checksum policy", p)
+	}
+
+	p, err = getChecksumPolicy(checksumsTask(map[string]string{
+		outputChecksumTag:            "sha256",
+		inputChecksumTagPrefix + "0": "SHA256:" + strings.ToUpper(testSHA256),
+	}), config.Worker{OutputChecksum: "md5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.output != "sha256" {
+		t.Error("expected the tag to override the output checksum", p.output)
+	}
+	if c := p.inputs["/inputs/in.txt"]; c.algorithm != "sha256" || c.value != testSHA256 {
+		t.Error("unexpected input checksum", c)
+	}
+
+	for _, tags := range []map[string]string{
+		{outputChecksumTag: "crc32"},
+		{inputChecksumTagPrefix + "0": testSHA256},
+		{inputChecksumTagPrefix + "0": "crc32:1234"},
+		{inputChecksumTagPrefix + "1": "sha256:" + testSHA256},
+		{inputChecksumTagPrefix + "2": "sha256:" + testSHA256},
+		{inputChecksumTagPrefix + "x": "sha256:" + testSHA256},
+	} {
+		_, err := getChecksumPolicy(checksumsTask(tags), config.Worker{})
+		if err == nil {
+			t.Error("expected error for tags", tags)
+		}
+	}
+}
+
+func TestVerifyInput(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-checksums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	p := path.Join(tmp, "in.txt")
+	ioutil.WriteFile(p, []byte("test"), os.ModePerm)
+
+	store, _ := storage.Storage{}.WithConfig(config.StorageConfig{
+		Local: config.LocalStorage{AllowedDirs: []string{tmp}},
+	})
+	r := &DefaultWorker{Store: store}
+
+	err = r.verifyInput(ctx, "file://"+p, p, checksum{"sha256", testSHA256})
+	if err != nil {
+		t.Error(err)
+	}
+	err = r.verifyInput(ctx, "file://"+p, p, checksum{"md5", "098f6bcd4621d373cade4e832627b4f6"})
+	if err != nil {
+		t.Error(err)
+	}
+	err = r.verifyInput(ctx, "file://"+p, p, checksum{"md5", "d41d8cd98f00b204e9800998ecf8427e"})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Error("expected checksum mismatch error", err)
+	}
+}
+
+// md5Backend is a fake backend which reports the given MD5 for every file.
+type md5Backend struct {
+	fakeVersionedBackend
+	md5 string
+}
+
+func (f *md5Backend) Stat(ctx context.Context, url string) (*storage.Object, error) {
+	return &storage.Object{URL: url, MD5: f.md5}, nil
+}
+
+// Test that the downloaded file is verified even if the storage system
+// reports a matching MD5, and that a mismatched MD5 fails early.
+func TestVerifyInputStorageMD5(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-checksums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	p := path.Join(tmp, "in.txt")
+	ioutil.WriteFile(p, []byte("corrupt"), os.ModePerm)
+
+	expected := checksum{"md5", "098f6bcd4621d373cade4e832627b4f6"}
+	r := &DefaultWorker{Store: storage.Storage{}.WithBackend(&md5Backend{md5: expected.value})}
+	err = r.verifyInput(ctx, "fake://in.txt", p, expected)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Error("expected checksum mismatch error for the downloaded file", err)
+	}
+
+	ioutil.WriteFile(p, []byte("test"), os.ModePerm)
+	r.Store = storage.Storage{}.WithBackend(&md5Backend{md5: "d41d8cd98f00b204e9800998ecf8427e"})
+	err = r.verifyInput(ctx, "fake://in.txt", p, expected)
+	if err == nil || !strings.Contains(err.Error(), "storage reports") {
+		t.Error("expected checksum mismatch error from the storage system", err)
+	}
+}
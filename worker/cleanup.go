@@ -0,0 +1,229 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Working directory cleanup policies, see config.WorkDirCleanup.
+const (
+	deleteNever     = "never"
+	deleteAlways    = "always"
+	deleteOnSuccess = "on-success"
+)
+
+// doneFile is written to a task's working directory when the task is done.
+// It holds the task's final state. The janitor only deletes directories
+// which have a done file.
+const doneFile = ".funnel-done"
+
+// shouldDelete returns true if the cleanup policy deletes the working
+// directory of a task which ended in the given state.
+func shouldDelete(policy string, state tes.State) (bool, error) {
+	switch strings.ToLower(policy) {
+	case "", deleteNever:
+		return false, nil
+	case deleteAlways:
+		return true, nil
+	case deleteOnSuccess:
+		return state == tes.State_COMPLETE, nil
+	default:
+		return false, fmt.Errorf("unknown WorkDirCleanup.Delete policy: %s", policy)
+	}
+}
+
+// cleanupWorkDir deletes the task's working directory, if the cleanup policy
+// says so. Otherwise, the directory is marked as done, so that the node's
+// janitor can delete it later.
+func (r *DefaultWorker) cleanupWorkDir(state tes.State) {
+	dir := r.Mapper.dir
+	// Never delete the base WorkDir, which may be shared with other tasks.
+	if wd, _ := filepath.Abs(r.Conf.WorkDir); dir == "" || dir == wd {
+		return
+	}
+
+	del, err := shouldDelete(r.Conf.WorkDirCleanup.Delete, state)
+	if err != nil {
+		r.Event.Error("Couldn't clean up working directory", "error", err)
+	}
+
+	if del {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			r.Event.Error("Couldn't delete working directory", "dir", dir, "error", err)
+		} else {
+			r.Event.Info("Deleted working directory", "dir", dir)
+			return
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, doneFile), []byte(state.String()), 0644)
+	if err != nil && !os.IsNotExist(err) {
+		r.Event.Error("Couldn't mark working directory as done", "dir", dir, "error", err)
+	}
+}
+
+// Janitor deletes the working directories of finished tasks on a node,
+// according to the worker's WorkDirCleanup config.
+type Janitor struct {
+	Conf config.Worker
+	Log  *logger.Logger
+	// Running returns true if the task is running on the node.
+	// The directories of running tasks are never deleted.
+	Running func(taskID string) bool
+}
+
+// Run runs the janitor every Conf.WorkDirCleanup.CheckRate until the
+// context is canceled.
+func (j *Janitor) Run(ctx context.Context) {
+	rate := j.Conf.WorkDirCleanup.CheckRate
+	if rate <= 0 {
+		rate = time.Minute
+	}
+	ticker := time.NewTicker(rate)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.Clean()
+		}
+	}
+}
+
+// finishedDir describes the working directory of a finished task.
+type finishedDir struct {
+	path  string
+	state tes.State
+	done  time.Time
+	size  int64
+}
+
+// Clean checks the working directories once, deleting the directories
+// of finished tasks which the policy doesn't keep.
+func (j *Janitor) Clean() {
+	policy := j.Conf.WorkDirCleanup
+
+	dirs, err := j.finishedDirs()
+	if err != nil {
+		j.Log.Error("Couldn't list working directories", "error", err)
+		return
+	}
+
+	var kept []finishedDir
+	var total int64
+	for _, d := range dirs {
+		del, err := shouldDelete(policy.Delete, d.state)
+		if err != nil {
+			j.Log.Error("Couldn't clean up working directories", "error", err)
+			return
+		}
+		reason := "deleted by policy"
+		if !del && policy.KeepFor > 0 && time.Since(d.done) > policy.KeepFor {
+			del = true
+			reason = "older than KeepFor"
+		}
+
+		if del {
+			j.remove(d, reason)
+			continue
+		}
+		kept = append(kept, d)
+		total += d.size
+	}
+
+	if policy.MaxBytes <= 0 {
+		return
+	}
+
+	// Delete the oldest directories until the total size is under the limit.
+	sort.Slice(kept, func(a, b int) bool {
+		return kept[a].done.Before(kept[b].done)
+	})
+	for _, d := range kept {
+		if total <= policy.MaxBytes {
+			break
+		}
+		if j.remove(d, "kept directories are larger than MaxBytes") {
+			total -= d.size
+		}
+	}
+}
+
+// remove deletes a working directory, returning true if it was deleted.
+func (j *Janitor) remove(d finishedDir, reason string) bool {
+	err := os.RemoveAll(d.path)
+	if err != nil {
+		j.Log.Error("Couldn't delete working directory", "dir", d.path, "error", err)
+		return false
+	}
+	j.Log.Info("Deleted working directory", "dir", d.path, "reason", reason)
+	return true
+}
+
+// finishedDirs lists the working directories of finished tasks,
+// i.e. directories which have a done file and aren't running.
+func (j *Janitor) finishedDirs() ([]finishedDir, error) {
+	wd, err := filepath.Abs(j.Conf.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(wd)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []finishedDir
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		id := info.Name()
+		if j.Running != nil && j.Running(id) {
+			continue
+		}
+
+		p := filepath.Join(wd, id)
+		done := filepath.Join(p, doneFile)
+		fi, err := os.Stat(done)
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(done)
+		if err != nil {
+			continue
+		}
+
+		dirs = append(dirs, finishedDir{
+			path:  p,
+			state: tes.State(tes.State_value[strings.TrimSpace(string(b))]),
+			done:  fi.ModTime(),
+			size:  dirSize(p),
+		})
+	}
+	return dirs, nil
+}
+
+// dirSize returns the total size of the files in a directory.
+func dirSize(p string) int64 {
+	var size int64
+	filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	wd, err := ioutil.TempDir("", "funnel-test-janitor-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	// Creates a task working directory with a file of the given size.
+	// If state is UNKNOWN, the task is still running.
+	mkdir := func(id string, state tes.State, size int, age time.Duration) {
+		p := filepath.Join(wd, id)
+		os.MkdirAll(p, 0755)
+		ioutil.WriteFile(filepath.Join(p, "data"), make([]byte, size), 0644)
+		if state != tes.State_UNKNOWN {
+			done := filepath.Join(p, doneFile)
+			ioutil.WriteFile(done, []byte(state.String()), 0644)
+			mod := time.Now().Add(-age)
+			os.Chtimes(done, mod, mod)
+		}
+	}
+	exists := func(id string) bool {
+		_, err := os.Stat(filepath.Join(wd, id))
+		return err == nil
+	}
+
+	mkdir("complete", tes.State_COMPLETE, 100, time.Minute)
+	mkdir("failed-old", tes.State_SYSTEM_ERROR, 100, time.Hour*3)
+	mkdir("failed-1", tes.State_EXECUTOR_ERROR, 100, time.Minute*20)
+	mkdir("failed-2", tes.State_EXECUTOR_ERROR, 100, time.Minute*10)
+	mkdir("no-done-file", tes.State_UNKNOWN, 100, 0)
+	mkdir("running", tes.State_COMPLETE, 100, time.Hour*3)
+
+	conf := config.Worker{WorkDir: wd}
+	conf.WorkDirCleanup.Delete = "on-success"
+	conf.WorkDirCleanup.KeepFor = time.Hour * 2
+	conf.WorkDirCleanup.MaxBytes = 150
+
+	j := &Janitor{
+		Conf: conf,
+		Log:  logger.NewLogger("test-janitor", logger.DebugConfig()),
+		Running: func(id string) bool {
+			return id == "running"
+		},
+	}
+	j.Clean()
+
+	expected := map[string]bool{
+		// Deleted on success.
+		"complete": false,
+		// Older than KeepFor.
+		"failed-old": false,
+		// The oldest directory is deleted to stay under MaxBytes.
+		"failed-1": false,
+		"failed-2": true,
+		// The janitor doesn't know whether the task is done.
+		"no-done-file": true,
+		"running":      true,
+	}
+	for id, e := range expected {
+		if exists(id) != e {
+			t.Errorf("%s: expected exists = %t", id, e)
+		}
+	}
+}
+
+func TestShouldDelete(t *testing.T) {
+	if del, _ := shouldDelete("on-success", tes.State_EXECUTOR_ERROR); del {
+		t.Error("expected failed task directory to be kept")
+	}
+	if del, _ := shouldDelete("always", tes.State_EXECUTOR_ERROR); !del {
+		t.Error("expected failed task directory to be deleted")
+	}
+	if del, _ := shouldDelete("", tes.State_COMPLETE); del {
+		t.Error("expected directories to be kept by default")
+	}
+	if _, err := shouldDelete("sometimes", tes.State_COMPLETE); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
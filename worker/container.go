@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io"
+)
+
+// ContainerConfig describes the container which runs a single executor.
+// All container runtimes share this configuration, so that volumes,
+// environment, working directory and stdio behave the same in each runtime.
+// Resources are applied as container limits, and the network and user
+// options are applied, by the runtimes which support them (docker and podman).
+type ContainerConfig struct {
+	Image           string
+	Command         []string
+	Volumes         []Volume
+	Workdir         string
+	Name            string
+	RemoveContainer bool
+	Env             map[string]string
+	Resources       *tes.Resources
+	Stdin           io.Reader
+	Stdout          io.Writer
+	Stderr          io.Writer
+	Event           *events.ExecutorWriter
+	// NetworkMode is the container's network mode: none, bridge or host.
+	// Empty uses the runtime's default.
+	NetworkMode string
+	// User is the "uid:gid" the container runs as.
+	// Empty uses the image's user.
+	User string
+	// Groups are the supplementary groups of the container's user.
+	Groups []string
+	// PullPolicy controls when the image is pulled,
+	// see config.Worker.PullPolicy.
+	PullPolicy string
+	// RegistryAuth holds credentials for pulling from private registries.
+	RegistryAuth RegistryCredentials
+	// ImageDigest, if set, is called with the digest of the image
+	// by the runtimes which can resolve it.
+	ImageDigest func(digest string)
+}
+
+// ContainerCommand runs an executor's command, usually inside a container.
+type ContainerCommand interface {
+	// Run runs the command and blocks until it exits.
+	Run(context.Context) error
+	// Stop stops the running command.
+	Stop() error
+	// Pause freezes the running command, e.g. when the task is paused.
+	Pause() error
+	// Resume unfreezes a paused command.
+	Resume() error
+}
+
+// ContainerRuntime creates a ContainerCommand from a ContainerConfig.
+type ContainerRuntime func(ContainerConfig) ContainerCommand
+
+// NewContainerRuntime returns the ContainerRuntime named by conf.ContainerRuntime.
+// If the name is empty, Docker is used.
+func NewContainerRuntime(conf config.Worker) (ContainerRuntime, error) {
+	switch conf.ContainerRuntime {
+	case "", "docker":
+		return func(c ContainerConfig) ContainerCommand {
+			return &DockerCommand{ContainerConfig: c}
+		}, nil
+
+	case "podman":
+		return func(c ContainerConfig) ContainerCommand {
+			return &PodmanCommand{ContainerConfig: c}
+		}, nil
+
+	case "singularity", "apptainer":
+		bin := conf.ContainerRuntimes.Singularity.Command
+		if bin == "" {
+			bin = conf.ContainerRuntime
+		}
+		return func(c ContainerConfig) ContainerCommand {
+			return &SingularityCommand{ContainerConfig: c, Binary: bin}
+		}, nil
+
+	case "exec":
+		return func(c ContainerConfig) ContainerCommand {
+			return &ExecCommand{ContainerConfig: c}
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown container runtime: %s", conf.ContainerRuntime)
+}
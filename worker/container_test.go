@@ -0,0 +1,278 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewContainerRuntime(t *testing.T) {
+	conf := config.Worker{}
+
+	for _, name := range []string{"", "docker", "podman", "singularity", "apptainer", "exec"} {
+		conf.ContainerRuntime = name
+		_, err := NewContainerRuntime(conf)
+		if err != nil {
+			t.Errorf("unexpected error for runtime %q: %s", name, err)
+		}
+	}
+
+	conf.ContainerRuntime = "foo"
+	_, err := NewContainerRuntime(conf)
+	if err == nil {
+		t.Error("expected error for unknown runtime")
+	}
+}
+
+func TestMapContainerPaths(t *testing.T) {
+	vols := []Volume{
+		{HostPath: "/host/data", ContainerPath: "/data"},
+		{HostPath: "/host/data-sub", ContainerPath: "/data/sub"},
+	}
+
+	tests := map[string]string{
+		"/data":                    "/host/data",
+		"/data/file.txt":           "/host/data/file.txt",
+		"/data/sub/file.txt":       "/host/data-sub/file.txt",
+		"/database":                "/database",
+		"/opt/data":                "/opt/data",
+		"cat /data/a > /data/b":    "cat /host/data/a > /host/data/b",
+		"--in=/data/a,/data/sub/b": "--in=/host/data/a,/host/data-sub/b",
+	}
+
+	for in, expected := range tests {
+		out := mapContainerPaths(in, vols)
+		if out != expected {
+			t.Errorf("mapContainerPaths(%q): expected %q, got %q", in, expected, out)
+		}
+	}
+}
+
+func TestExecCommand(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "funnel-test-exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = ioutil.WriteFile(path.Join(tmp, "in.txt"), []byte("hello\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	cmd := &ExecCommand{
+		ContainerConfig: ContainerConfig{
+			Command: []string{"sh", "-c", "cat /inputs/in.txt && echo $OUT > /outputs/out.txt"},
+			Env:     map[string]string{"OUT": "/outputs/out.txt"},
+			Volumes: []Volume{
+				{HostPath: tmp, ContainerPath: "/inputs", Readonly: true},
+				{HostPath: tmp, ContainerPath: "/outputs"},
+			},
+			Stdout: stdout,
+			Event:  events.NewExecutorWriter("test-task", 0, 0, "info", events.MultiWriter()),
+		},
+	}
+
+	err = cmd.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != "hello\n" {
+		t.Errorf("unexpected stdout: %q", stdout.String())
+	}
+
+	b, err := ioutil.ReadFile(path.Join(tmp, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != path.Join(tmp, "out.txt")+"\n" {
+		t.Errorf("unexpected output file content: %q", string(b))
+	}
+}
+
+// Tests that stopping a process also stops its child processes.
+func TestProcessStop(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "funnel-test-process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	pidfile := path.Join(tmp, "pid")
+
+	p := &process{}
+	cmd := exec.Command("sh", "-c", "sleep 60 & echo $! > "+pidfile+"; wait")
+	done := make(chan error, 1)
+	go func() {
+		done <- p.run(context.Background(), cmd)
+	}()
+
+	var child int
+	for i := 0; i < 100 && child == 0; i++ {
+		time.Sleep(time.Millisecond * 20)
+		b, _ := ioutil.ReadFile(pidfile)
+		child, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if child == 0 {
+		t.Fatal("child process didn't start")
+	}
+
+	err = p.stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("process didn't stop")
+	}
+
+	// The child is either gone, or a zombie waiting to be reaped.
+	for i := 0; i < 100 && processAlive(child); i++ {
+		time.Sleep(time.Millisecond * 20)
+	}
+	if processAlive(child) {
+		t.Error("expected the child process to be stopped")
+	}
+
+	// Stopping an exited process does nothing.
+	if err := p.stop(); err != nil {
+		t.Error(err)
+	}
+	if err := p.signal(syscall.SIGCONT); err == nil {
+		t.Error("expected error signaling an exited process")
+	}
+}
+
+// Tests that a process isn't started once its context is done,
+// e.g. when the task was canceled before the executor started.
+func TestProcessRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p := &process{}
+	cmd := exec.Command("true")
+	err := p.run(ctx, cmd)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if cmd.Process != nil {
+		t.Error("expected the process not to be started")
+	}
+	if err := p.stop(); err != nil {
+		t.Error(err)
+	}
+}
+
+// processAlive returns true if the process exists and isn't a zombie.
+func processAlive(pid int) bool {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name, which is in parentheses.
+	s := string(b)
+	i := strings.LastIndex(s, ")")
+	return i == -1 || !strings.HasPrefix(s[i+1:], " Z")
+}
+
+func TestDockerContainerConfig(t *testing.T) {
+	cmd := &DockerCommand{
+		ContainerConfig: ContainerConfig{
+			Image:   "alpine",
+			Command: []string{"echo", "hello"},
+			Env:     map[string]string{"FOO": "bar"},
+			Workdir: "/work",
+			Volumes: []Volume{
+				{HostPath: "/host/in", ContainerPath: "/in", Readonly: true},
+				{HostPath: "/host/out", ContainerPath: "/out"},
+			},
+		},
+	}
+
+	conf, hconf := cmd.containerConfig()
+	if conf.Image != "alpine" || conf.WorkingDir != "/work" {
+		t.Errorf("unexpected container config: %+v", conf)
+	}
+	if len(conf.Env) != 1 || conf.Env[0] != "FOO=bar" {
+		t.Errorf("unexpected env: %v", conf.Env)
+	}
+	if conf.AttachStdin || conf.OpenStdin {
+		t.Error("expected stdin to be closed when there is no stdin")
+	}
+	expected := []string{"/host/in:/in:ro", "/host/out:/out:rw"}
+	if len(hconf.Binds) != 2 || hconf.Binds[0] != expected[0] || hconf.Binds[1] != expected[1] {
+		t.Errorf("unexpected binds: %v", hconf.Binds)
+	}
+
+	if hconf.Memory != 0 || hconf.CPUQuota != 0 || hconf.StorageOpt != nil {
+		t.Errorf("expected no resource limits: %+v", hconf.Resources)
+	}
+
+	cmd.Stdin = &bytes.Buffer{}
+	cmd.Resources = &tes.Resources{CpuCores: 2, RamGb: 1.5, DiskGb: 10}
+	conf, hconf = cmd.containerConfig()
+	if !conf.AttachStdin || !conf.OpenStdin || !conf.StdinOnce {
+		t.Error("expected stdin to be attached")
+	}
+	if hconf.CPUQuota != 2*hconf.CPUPeriod {
+		t.Errorf("unexpected cpu limit: quota %d period %d", hconf.CPUQuota, hconf.CPUPeriod)
+	}
+	if hconf.Memory != 1610612736 || hconf.MemorySwap != hconf.Memory {
+		t.Errorf("unexpected memory limit: %d swap %d", hconf.Memory, hconf.MemorySwap)
+	}
+	if hconf.StorageOpt["size"] != "10737418240" {
+		t.Errorf("unexpected disk quota: %v", hconf.StorageOpt)
+	}
+}
+
+func TestGetExitCode(t *testing.T) {
+	if c := getExitCode(nil); c != 0 {
+		t.Errorf("expected 0, got %d", c)
+	}
+	if c := getExitCode(&exitError{code: 3}); c != 3 {
+		t.Errorf("expected 3, got %d", c)
+	}
+	err := &systemError{fmt.Errorf("image not found")}
+	if !isSystemError(err) {
+		t.Error("expected system error")
+	}
+	if c := getExitCode(err); c != -999 {
+		t.Errorf("expected -999, got %d", c)
+	}
+}
+
+func TestDockerIsolation(t *testing.T) {
+	cmd := &DockerCommand{
+		ContainerConfig: ContainerConfig{
+			Image:       "alpine",
+			NetworkMode: "none",
+			User:        "1000:1000",
+			Groups:      []string{"data"},
+		},
+	}
+
+	conf, hconf := cmd.containerConfig()
+	if conf.User != "1000:1000" {
+		t.Errorf("unexpected user: %s", conf.User)
+	}
+	if hconf.NetworkMode != "none" {
+		t.Errorf("unexpected network mode: %s", hconf.NetworkMode)
+	}
+	if len(hconf.GroupAdd) != 1 || hconf.GroupAdd[0] != "data" {
+		t.Errorf("unexpected groups: %v", hconf.GroupAdd)
+	}
+}
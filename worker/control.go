@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"sync"
+	"time"
+)
+
+// taskControl tracks the command of the running executor, so that it can be
+// frozen when the task is paused and unfrozen when the task is resumed.
+//
+// A paused task also doesn't start its next executor until it is resumed.
+type taskControl struct {
+	mtx    sync.Mutex
+	cmd    ContainerCommand
+	paused bool
+	frozen bool
+	// resumed is closed while the task isn't paused.
+	resumed chan struct{}
+}
+
+func newTaskControl() *taskControl {
+	c := &taskControl{resumed: make(chan struct{})}
+	close(c.resumed)
+	return c
+}
+
+// setCommand sets the command of the running executor.
+// A nil command means no executor is running.
+func (c *taskControl) setCommand(cmd ContainerCommand) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.cmd = cmd
+	c.frozen = false
+}
+
+// pause marks the task as paused and freezes the running executor,
+// returning true if the executor was frozen by this call. Freezing may fail,
+// e.g. if the container hasn't started yet, so pause is called again on
+// each update until it succeeds.
+func (c *taskControl) pause() (bool, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+	}
+	if c.cmd == nil || c.frozen {
+		return false, nil
+	}
+	err := c.cmd.Pause()
+	if err != nil {
+		return false, err
+	}
+	c.frozen = true
+	return true, nil
+}
+
+// resume marks the task as running and unfreezes the running executor,
+// returning true if the executor was unfrozen by this call.
+func (c *taskControl) resume() (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+	}
+	if c.cmd == nil || !c.frozen {
+		return false, nil
+	}
+	err := c.cmd.Resume()
+	if err != nil {
+		return false, err
+	}
+	c.frozen = false
+	return true, nil
+}
+
+// wait blocks while the task is paused, or until the context is canceled.
+func (c *taskControl) wait(ctx context.Context) error {
+	c.mtx.Lock()
+	resumed := c.resumed
+	c.mtx.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// watchState polls the task's state every Conf.UpdateRate and controls the
+// task accordingly:
+//
+// - PAUSED freezes the running executor, see taskControl.
+// - RUNNING unfreezes a paused executor.
+// - a terminal state, e.g. CANCELED, cancels the returned context and calls "stopped".
+// - QUEUED, once the task has started, means the server requeued the task,
+// e.g. because it considered the worker lost, so it also cancels the
+// returned context and calls "stopped".
+func (r *DefaultWorker) watchState(ctx context.Context, control *taskControl, stopped func(tes.State)) context.Context {
+	taskctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		ticker := time.NewTicker(r.Conf.UpdateRate)
+		defer ticker.Stop()
+		started := false
+
+		for {
+			select {
+			case <-taskctx.Done():
+				return
+			case <-ticker.C:
+				state, err := r.TaskReader.State()
+				if err != nil {
+					continue
+				}
+
+				if tes.RunnableState(state) || state == tes.State_PAUSED {
+					started = true
+				}
+
+				switch {
+				case tes.TerminalState(state) || (started && state == tes.State_QUEUED):
+					// A frozen executor can't stop gracefully,
+					// so it is unfrozen before the task is canceled.
+					control.resume()
+					cancel()
+					stopped(state)
+
+				case state == tes.State_PAUSED:
+					frozen, err := control.pause()
+					if err != nil {
+						r.Event.Error("Couldn't pause executor", "error", err)
+					} else if frozen {
+						r.Event.Info("Paused")
+					}
+
+				case state == tes.State_RUNNING:
+					unfrozen, err := control.resume()
+					if err != nil {
+						r.Event.Error("Couldn't resume executor", "error", err)
+					} else if unfrozen {
+						r.Event.Info("Resumed")
+					}
+				}
+			}
+		}
+	}()
+	return taskctx
+}
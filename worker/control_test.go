@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// pauseCommand is a ContainerCommand which records pause/resume calls.
+type pauseCommand struct {
+	paused   int
+	resumed  int
+	pauseErr error
+}
+
+func (p *pauseCommand) Run(context.Context) error { return nil }
+func (p *pauseCommand) Stop() error               { return nil }
+
+func (p *pauseCommand) Pause() error {
+	if p.pauseErr != nil {
+		return p.pauseErr
+	}
+	p.paused++
+	return nil
+}
+
+func (p *pauseCommand) Resume() error {
+	p.resumed++
+	return nil
+}
+
+func TestTaskControlPauseResume(t *testing.T) {
+	c := newTaskControl()
+	cmd := &pauseCommand{pauseErr: fmt.Errorf("container isn't running yet")}
+	c.setCommand(cmd)
+
+	// A failed pause is retried on the next update.
+	frozen, err := c.pause()
+	if err == nil || frozen {
+		t.Fatal("expected pause error")
+	}
+	cmd.pauseErr = nil
+	frozen, err = c.pause()
+	if err != nil || !frozen {
+		t.Fatal("expected executor to be frozen", err)
+	}
+	// Pausing again doesn't freeze the executor twice.
+	frozen, _ = c.pause()
+	if frozen || cmd.paused != 1 {
+		t.Fatal("expected executor to be frozen once")
+	}
+
+	unfrozen, err := c.resume()
+	if err != nil || !unfrozen {
+		t.Fatal("expected executor to be unfrozen", err)
+	}
+	unfrozen, _ = c.resume()
+	if unfrozen || cmd.resumed != 1 {
+		t.Fatal("expected executor to be unfrozen once")
+	}
+}
+
+func TestTaskControlWait(t *testing.T) {
+	c := newTaskControl()
+	if err := c.wait(context.Background()); err != nil {
+		t.Fatal("expected running task not to wait", err)
+	}
+
+	// No executor is running, so pausing only blocks the next executor.
+	c.pause()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.wait(context.Background())
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected paused task to wait")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	c.resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected resumed task to stop waiting")
+	}
+
+	// A canceled task stops waiting.
+	c.pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.wait(ctx); err != context.Canceled {
+		t.Fatal("expected context error", err)
+	}
+}
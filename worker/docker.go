@@ -1,91 +1,171 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/ohsu-comp-bio/funnel/util"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 )
 
 // DockerCommand is responsible for configuring and running a docker container.
+// The container lifecycle (pull, create, attach, wait, remove) is driven
+// through the Docker Engine API.
 type DockerCommand struct {
-	Image           string
-	Command         []string
-	Volumes         []Volume
-	Workdir         string
-	ContainerName   string
-	RemoveContainer bool
-	Env             map[string]string
-	Stdin           io.Reader
-	Stdout          io.Writer
-	Stderr          io.Writer
-	Event           *events.ExecutorWriter
+	ContainerConfig
 }
 
-// Run runs the Docker command and blocks until done.
-func (dcmd DockerCommand) Run() error {
-	// (Hopefully) temporary hack to sync docker API version info.
-	// Don't need the client here, just the logic inside NewDockerClient().
-	_, derr := util.NewDockerClient()
-	if derr != nil {
-		dcmd.Event.Error("Can't connect to Docker", derr)
-		return derr
+// Run runs the Docker container and blocks until done.
+//
+// Failures to pull the image or create the container are returned as system
+// errors, since the executor's command never ran. A non-zero exit code from
+// the container is returned as an *exitError.
+func (dcmd *DockerCommand) Run(ctx context.Context) error {
+	dclient, err := util.NewDockerClient()
+	if err != nil {
+		dcmd.Event.Error("Can't connect to Docker", "error", err)
+		return &systemError{err}
 	}
+	// close the docker client connection
+	defer dclient.Close()
 
-	pullcmd := exec.Command("docker", "pull", dcmd.Image)
-	pullcmd.Run()
+	err = dcmd.pull(ctx, dclient)
+	if err != nil {
+		return &systemError{err}
+	}
+	dcmd.recordDigest(ctx, dclient)
 
-	args := []string{"run", "-i"}
+	conf, hconf := dcmd.containerConfig()
+	dcmd.Event.Info("Creating container", "name", dcmd.Name, "image", dcmd.Image, "cmd", strings.Join(dcmd.Command, " "))
+	created, err := dclient.ContainerCreate(ctx, conf, hconf, nil, dcmd.Name)
+	if err != nil && hconf.StorageOpt != nil {
+		// Disk quotas are only supported by some storage drivers,
+		// so try again without the quota.
+		dcmd.Event.Info("Failed to create container with a disk quota, retrying without the quota", "error", err)
+		hconf.StorageOpt = nil
+		created, err = dclient.ContainerCreate(ctx, conf, hconf, nil, dcmd.Name)
+	}
+	if err != nil {
+		dcmd.Event.Error("Failed to create container", "error", err)
+		return &systemError{err}
+	}
+	id := created.ID
+	for _, w := range created.Warnings {
+		dcmd.Event.Info("Docker warning", "warning", w)
+	}
 
 	if dcmd.RemoveContainer {
-		args = append(args, "--rm")
+		defer func() {
+			// Use a new context, since "ctx" might be canceled.
+			rerr := dclient.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{
+				Force: true,
+			})
+			if rerr != nil {
+				dcmd.Event.Error("Failed to remove container", "error", rerr)
+			}
+		}()
 	}
 
-	if dcmd.Env != nil {
-		for k, v := range dcmd.Env {
-			args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
-		}
+	attached, err := dclient.ContainerAttach(ctx, id, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  conf.AttachStdin,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		dcmd.Event.Error("Failed to attach to container", "error", err)
+		return &systemError{err}
 	}
+	defer attached.Close()
 
-	if dcmd.ContainerName != "" {
-		args = append(args, "--name", dcmd.ContainerName)
-	}
+	// Stream stdout/err until the container exits and the connection is closed.
+	streamDone := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(writerOrDiscard(dcmd.Stdout), writerOrDiscard(dcmd.Stderr), attached.Reader)
+		streamDone <- err
+	}()
 
-	if dcmd.Workdir != "" {
-		args = append(args, "-w", dcmd.Workdir)
+	if dcmd.Stdin != nil {
+		go func() {
+			io.Copy(attached.Conn, dcmd.Stdin)
+			attached.CloseWrite()
+		}()
 	}
 
-	for _, vol := range dcmd.Volumes {
-		arg := formatVolumeArg(vol)
-		args = append(args, "-v", arg)
+	err = dclient.ContainerStart(ctx, id, types.ContainerStartOptions{})
+	if err != nil {
+		dcmd.Event.Error("Failed to start container", "error", err)
+		return &systemError{err}
 	}
 
-	args = append(args, dcmd.Image)
-	args = append(args, dcmd.Command...)
-
-	// Roughly: `docker run --rm -i -w [workdir] -v [bindings] [imageName] [cmd]`
-	dcmd.Event.Info("Running command", "cmd", "docker "+strings.Join(args, " "))
-	cmd := exec.Command("docker", args...)
+	// Sample the container's resource usage until it exits.
+	usage := &usageTracker{}
+	statsctx, stopStats := context.WithCancel(context.Background())
+	statsDone := make(chan struct{})
+	go func() {
+		defer close(statsDone)
+		usage.trackDocker(statsctx, dclient, id)
+	}()
+	defer func() {
+		stopStats()
+		<-statsDone
+		if u := usage.usage(); u != nil {
+			dcmd.Event.ResourceUsage(u)
+		}
+	}()
 
-	if dcmd.Stdin != nil {
-		cmd.Stdin = dcmd.Stdin
+	// Wait using a new context, so that when the task is canceled
+	// the container has a chance to be stopped gracefully via Stop().
+	waitc, errc := dclient.ContainerWait(context.Background(), id, container.WaitConditionNotRunning)
+	var code int64
+	select {
+	case res := <-waitc:
+		if res.Error != nil {
+			return &systemError{fmt.Errorf("error waiting for container: %s", res.Error.Message)}
+		}
+		code = res.StatusCode
+	case err := <-errc:
+		return &systemError{err}
 	}
-	if dcmd.Stdout != nil {
-		cmd.Stdout = dcmd.Stdout
+
+	// Make sure all the output has been written before returning.
+	select {
+	case err := <-streamDone:
+		if err != nil {
+			dcmd.Event.Error("Error streaming container logs", "error", err)
+		}
+	case <-time.After(time.Second * 10):
+		dcmd.Event.Error("Timed out waiting for container logs")
 	}
-	if dcmd.Stderr != nil {
-		cmd.Stderr = dcmd.Stderr
+
+	if code != 0 {
+		exiterr := &exitError{code: int(code)}
+		// Use a new context, since "ctx" might be canceled.
+		info, err := dclient.ContainerInspect(context.Background(), id)
+		if err == nil && info.State != nil && info.State.OOMKilled {
+			exiterr.oomKilled = true
+			dcmd.Event.Error("Container was killed because it ran out of memory",
+				"memory limit (bytes)", hconf.Memory, "exit code", code)
+		}
+		return exiterr
 	}
-	return cmd.Run()
+	return nil
 }
 
 // Stop stops the container.
-func (dcmd DockerCommand) Stop() error {
-	dcmd.Event.Info("Stopping container", "container", dcmd.ContainerName)
+func (dcmd *DockerCommand) Stop() error {
+	dcmd.Event.Info("Stopping container", "container", dcmd.Name)
 	dclient, derr := util.NewDockerClient()
 	if derr != nil {
 		return derr
@@ -96,10 +176,289 @@ func (dcmd DockerCommand) Stop() error {
 	timeout := time.Second * 10
 	// Issue stop call
 	// TODO is context.Background right?
-	err := dclient.ContainerStop(context.Background(), dcmd.ContainerName, &timeout)
+	err := dclient.ContainerStop(context.Background(), dcmd.Name, &timeout)
 	return err
 }
 
+// Pause freezes the container's processes.
+func (dcmd *DockerCommand) Pause() error {
+	dclient, derr := util.NewDockerClient()
+	if derr != nil {
+		return derr
+	}
+	defer dclient.Close()
+	return dclient.ContainerPause(context.Background(), dcmd.Name)
+}
+
+// Resume unfreezes the container's processes.
+func (dcmd *DockerCommand) Resume() error {
+	dclient, derr := util.NewDockerClient()
+	if derr != nil {
+		return derr
+	}
+	defer dclient.Close()
+	return dclient.ContainerUnpause(context.Background(), dcmd.Name)
+}
+
+// pull pulls the container image according to the pull policy,
+// logging the progress of the pull.
+func (dcmd *DockerCommand) pull(ctx context.Context, dclient *client.Client) error {
+	switch dcmd.PullPolicy {
+	case "", pullAlways:
+	case pullIfNotPresent, pullNever:
+		_, _, err := dclient.ImageInspectWithRaw(ctx, dcmd.Image)
+		if err == nil {
+			dcmd.Event.Info("Using local image", "image", dcmd.Image, "pull policy", dcmd.PullPolicy)
+			return nil
+		}
+		if !client.IsErrImageNotFound(err) {
+			dcmd.Event.Error("Failed to inspect image", "image", dcmd.Image, "error", err)
+			return err
+		}
+		if dcmd.PullPolicy == pullNever {
+			err = fmt.Errorf("image not found: %s: the pull policy is %q", dcmd.Image, pullNever)
+			dcmd.Event.Error("Failed to find image", "image", dcmd.Image, "error", err)
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown pull policy: %s", dcmd.PullPolicy)
+	}
+
+	dcmd.Event.Info("Pulling image", "image", dcmd.Image)
+	err := dcmd.doPull(ctx, dclient)
+	if err != nil {
+		dcmd.Event.Error("Failed to pull image", "image", dcmd.Image, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (dcmd *DockerCommand) doPull(ctx context.Context, dclient *client.Client) error {
+	auth, err := dcmd.RegistryAuth.forImage(dcmd.Image)
+	if err != nil {
+		return err
+	}
+
+	body, err := dclient.ImagePull(ctx, dcmd.Image, types.ImagePullOptions{
+		RegistryAuth: auth,
+	})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// The pull progress is a stream of JSON messages. Errors which happen
+	// during the pull are reported in the stream, not by ImagePull.
+	dec := json.NewDecoder(body)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		// Skip the (very frequent) progress bar updates.
+		if msg.Progress != nil && msg.Progress.Total > 0 {
+			continue
+		}
+		if msg.ID != "" {
+			dcmd.Event.Debug("Image pull progress", "layer", msg.ID, "status", msg.Status)
+		} else if msg.Status != "" {
+			dcmd.Event.Info("Image pull progress", "status", msg.Status)
+		}
+	}
+}
+
+// recordDigest resolves the digest of the container image,
+// so that the exact image which ran is recorded.
+func (dcmd *DockerCommand) recordDigest(ctx context.Context, dclient *client.Client) {
+	if dcmd.ImageDigest == nil {
+		return
+	}
+	info, _, err := dclient.ImageInspectWithRaw(ctx, dcmd.Image)
+	if err != nil {
+		dcmd.Event.Error("Failed to resolve image digest", "image", dcmd.Image, "error", err)
+		return
+	}
+	digest := imageDigest(dcmd.Image, info)
+	dcmd.Event.Info("Resolved image digest", "image", dcmd.Image, "digest", digest)
+	dcmd.ImageDigest(digest)
+}
+
+// containerConfig converts the ContainerConfig into docker's container and
+// host configuration.
+func (dcmd *DockerCommand) containerConfig() (*container.Config, *container.HostConfig) {
+	var env []string
+	for k, v := range dcmd.Env {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	var binds []string
+	for _, vol := range dcmd.Volumes {
+		binds = append(binds, formatVolumeArg(vol))
+	}
+
+	stdin := dcmd.Stdin != nil
+	conf := &container.Config{
+		Image:        dcmd.Image,
+		Cmd:          dcmd.Command,
+		Env:          env,
+		WorkingDir:   dcmd.Workdir,
+		AttachStdin:  stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		OpenStdin:    stdin,
+		StdinOnce:    stdin,
+		User:         dcmd.User,
+	}
+	hconf := &container.HostConfig{
+		Binds:       binds,
+		NetworkMode: container.NetworkMode(dcmd.NetworkMode),
+		GroupAdd:    dcmd.Groups,
+	}
+
+	if r := dcmd.Resources; r != nil {
+		if r.CpuCores > 0 {
+			hconf.CPUPeriod = cpuPeriod
+			hconf.CPUQuota = int64(r.CpuCores) * cpuPeriod
+		}
+		if r.RamGb > 0 {
+			hconf.Memory = gbToBytes(r.RamGb)
+			// Disable swap, so that the memory limit is a hard limit.
+			hconf.MemorySwap = hconf.Memory
+		}
+		if r.DiskGb > 0 {
+			hconf.StorageOpt = map[string]string{
+				"size": fmt.Sprintf("%d", gbToBytes(r.DiskGb)),
+			}
+		}
+	}
+	return conf, hconf
+}
+
+// cpuPeriod is the CFS scheduler period (in microseconds) used to limit
+// the CPU usage of a container.
+const cpuPeriod int64 = 100000
+
+// gbToBytes converts gigabytes (as used by tes.Resources) to bytes.
+func gbToBytes(gb float64) int64 {
+	return int64(gb * 1024 * 1024 * 1024)
+}
+
+func writerOrDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return ioutil.Discard
+	}
+	return w
+}
+
+// runArgs builds the arguments to a docker-compatible "run" command,
+// as used by the podman CLI. "envFile" is the path of the file holding
+// the container's env variables, see writeEnvFile, or empty.
+func runArgs(c ContainerConfig, envFile string) []string {
+	args := []string{"run", "-i"}
+
+	if c.RemoveContainer {
+		args = append(args, "--rm")
+	}
+
+	if r := c.Resources; r != nil {
+		if r.CpuCores > 0 {
+			args = append(args, "--cpus", fmt.Sprintf("%d", r.CpuCores))
+		}
+		if r.RamGb > 0 {
+			mem := fmt.Sprintf("%db", gbToBytes(r.RamGb))
+			// Disable swap, so that the memory limit is a hard limit.
+			args = append(args, "--memory", mem, "--memory-swap", mem)
+		}
+	}
+
+	// The env variables are passed in a file, so that values (e.g. secrets)
+	// aren't logged, and don't change the environment of the CLI itself.
+	if envFile != "" {
+		args = append(args, "--env-file", envFile)
+	}
+
+	if c.Name != "" {
+		args = append(args, "--name", c.Name)
+	}
+
+	if c.NetworkMode != "" {
+		args = append(args, "--network", c.NetworkMode)
+	}
+
+	if c.User != "" {
+		args = append(args, "--user", c.User)
+	}
+
+	for _, g := range c.Groups {
+		args = append(args, "--group-add", g)
+	}
+
+	if c.Workdir != "" {
+		args = append(args, "-w", c.Workdir)
+	}
+
+	for _, vol := range c.Volumes {
+		arg := formatVolumeArg(vol)
+		args = append(args, "-v", arg)
+	}
+
+	args = append(args, c.Image)
+	args = append(args, c.Command...)
+	return args
+}
+
+// writeEnvFile writes the container's env variables to a temporary file,
+// in the format of the "--env-file" argument of a docker-compatible "run"
+// command. The caller must remove the file. If there are no env variables,
+// no file is written and an empty path is returned.
+func writeEnvFile(c ContainerConfig) (string, error) {
+	if len(c.Env) == 0 {
+		return "", nil
+	}
+
+	var b bytes.Buffer
+	for k, v := range c.Env {
+		// The file has one variable per line, without quoting.
+		if strings.ContainsAny(k+v, "\n\r") {
+			return "", fmt.Errorf("env variable %s: values with newlines aren't supported", k)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", k, v)
+	}
+
+	// TempFile creates the file readable by the owner only.
+	f, err := ioutil.TempFile("", "funnel-env-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = f.Write(b.Bytes())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// setStdio connects the container's stdin/out/err to the given command.
+func setStdio(cmd *exec.Cmd, c ContainerConfig) {
+	if c.Stdin != nil {
+		cmd.Stdin = c.Stdin
+	}
+	if c.Stdout != nil {
+		cmd.Stdout = c.Stdout
+	}
+	if c.Stderr != nil {
+		cmd.Stderr = c.Stderr
+	}
+}
+
 func formatVolumeArg(v Volume) string {
 	// `o` is structed as "HostPath:ContainerPath:Mode".
 	mode := "rw"
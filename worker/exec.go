@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ExecCommand runs an executor's command directly on the host, without
+// a container. The executor's image is ignored.
+//
+// Since there is no container to mount volumes into, container paths found in
+// the command, working directory and environment are rewritten to the
+// corresponding host paths from the volumes.
+type ExecCommand struct {
+	ContainerConfig
+	proc process
+}
+
+// Run runs the command and blocks until done.
+func (ecmd *ExecCommand) Run(ctx context.Context) error {
+	if len(ecmd.Command) == 0 {
+		return fmt.Errorf("no command to run")
+	}
+
+	args := make([]string, len(ecmd.Command))
+	for i, a := range ecmd.Command {
+		args[i] = mapContainerPaths(a, ecmd.Volumes)
+	}
+
+	ecmd.Event.Info("Running command", "cmd", strings.Join(args, " "))
+	cmd := exec.Command(args[0], args[1:]...)
+	setStdio(cmd, ecmd.ContainerConfig)
+
+	if ecmd.Workdir != "" {
+		cmd.Dir = mapContainerPaths(ecmd.Workdir, ecmd.Volumes)
+	}
+
+	cmd.Env = os.Environ()
+	for k, v := range ecmd.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, mapContainerPaths(v, ecmd.Volumes)))
+	}
+	start := time.Now()
+	err := ecmd.proc.run(ctx, cmd)
+	if u := rusageUsage(cmd.ProcessState, time.Since(start)); u != nil {
+		ecmd.Event.ResourceUsage(u)
+	}
+	return err
+}
+
+// Stop stops the process.
+func (ecmd *ExecCommand) Stop() error {
+	ecmd.Event.Info("Stopping process", "name", ecmd.Name)
+	return ecmd.proc.stop()
+}
+
+// Pause stops the process until it is resumed.
+func (ecmd *ExecCommand) Pause() error {
+	return ecmd.proc.signal(syscall.SIGSTOP)
+}
+
+// Resume continues the stopped process.
+func (ecmd *ExecCommand) Resume() error {
+	return ecmd.proc.signal(syscall.SIGCONT)
+}
+
+// mapContainerPaths replaces occurrences of volume container paths in "s"
+// with the volume's host path. A container path only matches as a whole path
+// component, so "/data" matches "/data/file" but not "/database".
+func mapContainerPaths(s string, vols []Volume) string {
+	// Replace the longest (most specific) paths first.
+	sorted := make([]Volume, len(vols))
+	copy(sorted, vols)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].ContainerPath) > len(sorted[j].ContainerPath)
+	})
+
+	for _, v := range sorted {
+		if v.ContainerPath == "" || v.ContainerPath == "/" {
+			continue
+		}
+		s = replacePath(s, v.ContainerPath, v.HostPath)
+	}
+	return s
+}
+
+// replacePath replaces each occurrence of the path "old" in "s" with "new",
+// where the occurrence is bounded by non-path characters.
+func replacePath(s, old, new string) string {
+	var out bytes.Buffer
+	// prev is the character preceding the unprocessed remainder of "s".
+	var prev byte
+	for {
+		i := strings.Index(s, old)
+		if i == -1 {
+			out.WriteString(s)
+			return out.String()
+		}
+		if i > 0 {
+			prev = s[i-1]
+		}
+		end := i + len(old)
+		before := prev == 0 || !isPathChar(prev)
+		after := end == len(s) || s[end] == '/' || !isPathChar(s[end])
+
+		out.WriteString(s[:i])
+		if before && after {
+			out.WriteString(new)
+		} else {
+			out.WriteString(old)
+		}
+		prev = old[len(old)-1]
+		s = s[end:]
+	}
+}
+
+func isPathChar(c byte) bool {
+	return c == '/' || c == '.' || c == '-' || c == '_' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"strconv"
+)
+
+// Task tags which change how the executor at index i is run,
+// e.g. "funnel_executor_background_0". Values are "true" or "false".
+const (
+	// continueOnErrorTag lets the task carry on with the next executor
+	// when the executor fails, e.g. for cleanup or report steps.
+	// The task doesn't fail because of the executor.
+	continueOnErrorTag = "funnel_executor_continue_on_error"
+	// backgroundTag runs the executor in the background, e.g. a database
+	// or monitoring sidecar, while the following executors run.
+	// Background executors are stopped once the other executors are done,
+	// and their failures don't fail the task.
+	backgroundTag = "funnel_executor_background"
+)
+
+// executorMode holds the options of an executor which are set by tags.
+type executorMode struct {
+	continueOnError bool
+	background      bool
+}
+
+// getExecutorModes gets the mode of each of the task's executors
+// from the task's tags.
+func getExecutorModes(task *tes.Task) ([]executorMode, error) {
+	tags := task.GetTags()
+	modes := make([]executorMode, len(task.GetExecutors()))
+
+	for i := range modes {
+		var err error
+		modes[i].continueOnError, err = parseBoolTag(tags, fmt.Sprintf("%s_%d", continueOnErrorTag, i))
+		if err != nil {
+			return nil, err
+		}
+		modes[i].background, err = parseBoolTag(tags, fmt.Sprintf("%s_%d", backgroundTag, i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return modes, nil
+}
+
+func parseBoolTag(tags map[string]string, key string) (bool, error) {
+	v, ok := tags[key]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s tag %q: expected true or false", key, v)
+	}
+	return b, nil
+}
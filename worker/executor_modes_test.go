@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"testing"
+)
+
+func TestGetExecutorModes(t *testing.T) {
+	task := &tes.Task{
+		Executors: []*tes.Executor{{}, {}, {}},
+		Tags: map[string]string{
+			"funnel_executor_background_0":        "true",
+			"funnel_executor_continue_on_error_2": "true",
+		},
+	}
+	modes, err := getExecutorModes(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []executorMode{
+		{background: true},
+		{},
+		{continueOnError: true},
+	}
+	for i, e := range expected {
+		if modes[i] != e {
+			t.Errorf("executor %d: expected %+v, got %+v", i, e, modes[i])
+		}
+	}
+}
+
+func TestGetExecutorModesInvalid(t *testing.T) {
+	task := &tes.Task{
+		Executors: []*tes.Executor{{}},
+		Tags: map[string]string{
+			"funnel_executor_background_0": "yes please",
+		},
+	}
+	_, err := getExecutorModes(task)
+	if err == nil {
+		t.Fatal("expected error for an invalid tag value")
+	}
+}
@@ -237,6 +237,9 @@ func (mapper *FileMapper) AddInput(input *tes.Input) error {
 // A copy of the tes.Output will be added to mapper.Outputs, with the
 // "Path" field updated to the mapped host path.
 //
+// If the path is a glob pattern, the pattern's base directory is mounted
+// and the pattern is matched when the outputs are uploaded.
+//
 // If the path can't be mapped, an error is returned.
 func (mapper *FileMapper) AddOutput(output *tes.Output) error {
 	hostPath, err := mapper.HostPath(output.Path)
@@ -246,7 +249,10 @@ func (mapper *FileMapper) AddOutput(output *tes.Output) error {
 
 	hostDir := hostPath
 	mountDir := output.Path
-	if output.Type == tes.FileType_FILE {
+	if isGlob(output.Path) {
+		hostDir = globBase(hostPath)
+		mountDir = globBase(output.Path)
+	} else if output.Type == tes.FileType_FILE {
 		hostDir = path.Dir(hostPath)
 		mountDir = path.Dir(output.Path)
 	}
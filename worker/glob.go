@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isGlob returns true if the path contains glob metacharacters,
+// see path.Match for the pattern syntax.
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// globBase returns the longest leading directory of a glob pattern
+// which doesn't contain any metacharacters,
+// e.g. "/outputs/sample-*/*.bam" returns "/outputs".
+func globBase(pattern string) string {
+	dir := path.Dir(pattern)
+	for isGlob(dir) {
+		dir = path.Dir(dir)
+	}
+	return dir
+}
+
+// expandGlob matches an output's glob pattern against the host file system,
+// returning an output for each match. Each match is uploaded to the output's
+// URL plus the path of the match relative to the pattern's base directory.
+//
+// Matching is done once the executors have finished. If nothing matches,
+// an error is returned.
+func expandGlob(output *tes.Output) ([]*tes.Output, error) {
+	matches, err := filepath.Glob(output.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match output pattern: %s", output.Path)
+	}
+
+	base := globBase(output.Path)
+	url := strings.TrimSuffix(output.Url, "/")
+
+	var outputs []*tes.Output
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(base, match)
+		if err != nil {
+			return nil, err
+		}
+
+		out := proto.Clone(output).(*tes.Output)
+		out.Path = match
+		out.Url = url + "/" + filepath.ToSlash(rel)
+		out.Type = tes.FileType_FILE
+		if info.IsDir() {
+			out.Type = tes.FileType_DIRECTORY
+		}
+		outputs = append(outputs, out)
+	}
+	return outputs, nil
+}
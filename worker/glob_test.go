@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobBase(t *testing.T) {
+	tests := map[string]string{
+		"/outputs/*.bam":          "/outputs",
+		"/outputs/sample-*/*.bam": "/outputs",
+		"/outputs/a/b/out?.txt":   "/outputs/a/b",
+		"/*.txt":                  "/",
+	}
+	for pattern, expected := range tests {
+		if base := globBase(pattern); base != expected {
+			t.Errorf("globBase(%q): expected %q, got %q", pattern, expected, base)
+		}
+	}
+}
+
+func TestExpandGlob(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "funnel-test-glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, p := range []string{"a/one.txt", "a/two.txt", "b/three.txt", "b/skip.log"} {
+		p = filepath.Join(tmp, p)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		ioutil.WriteFile(p, []byte("data"), 0644)
+	}
+
+	out, err := expandGlob(&tes.Output{
+		Url:  "file:///results/",
+		Path: tmp + "/*/*.txt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"file:///results/a/one.txt",
+		"file:///results/a/two.txt",
+		"file:///results/b/three.txt",
+	}
+	if len(out) != len(expected) {
+		t.Fatalf("expected %d matches, got %d", len(expected), len(out))
+	}
+	for i, o := range out {
+		if o.Url != expected[i] {
+			t.Errorf("expected url %q, got %q", expected[i], o.Url)
+		}
+		if o.Type != tes.FileType_FILE {
+			t.Errorf("expected FILE type for %s", o.Path)
+		}
+	}
+
+	out, err = expandGlob(&tes.Output{
+		Url:  "file:///results",
+		Path: tmp + "/?",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].Type != tes.FileType_DIRECTORY || out[0].Url != "file:///results/a" {
+		t.Error("unexpected directory matches", out)
+	}
+
+	_, err = expandGlob(&tes.Output{
+		Url:  "file:///results",
+		Path: tmp + "/*.bam",
+	})
+	if err == nil {
+		t.Error("expected error when nothing matches")
+	}
+}
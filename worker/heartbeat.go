@@ -0,0 +1,24 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// sendHeartbeats sends a heartbeat event every Conf.HeartbeatRate,
+// until the context is canceled, so that the server can tell that
+// the worker running the task is still alive.
+func (r *DefaultWorker) sendHeartbeats(ctx context.Context) {
+	ticker := time.NewTicker(r.Conf.HeartbeatRate)
+	defer ticker.Stop()
+
+	for {
+		r.Event.Heartbeat()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
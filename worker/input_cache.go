@@ -0,0 +1,315 @@
+package worker
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/storage"
+	"github.com/ohsu-comp-bio/funnel/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InputCache is a size-bounded cache of downloaded input files, stored on
+// the node's disk. Files are keyed by URL and storage version (e.g. ETag),
+// so a changed object is downloaded again. When the cache is full, the least
+// recently used files are evicted.
+//
+// Cached files are hard-linked into the task's working directory. If that's
+// not possible (e.g. the cache is on a different filesystem), the cached file
+// is bind-mounted read-only into the container instead.
+type InputCache struct {
+	dir      string
+	maxBytes int64
+
+	mtx     sync.Mutex
+	size    int64
+	entries map[string]*cacheEntry
+	// lru orders the entries from most (front) to least recently used.
+	lru *list.List
+	// pending tracks downloads in progress, so that concurrent requests
+	// for the same file only download it once.
+	pending map[string]chan struct{}
+}
+
+type cacheEntry struct {
+	key  string
+	path string
+	size int64
+	// refs counts the users of the entry. An entry with refs is not evicted.
+	refs int
+	elem *list.Element
+}
+
+// CachedInput describes an input file provided by the cache.
+type CachedInput struct {
+	// Hit is true if the file was already in the cache.
+	Hit bool
+	// Mount is the host path of the cached file, if the file couldn't be
+	// hard-linked to the input path and must be bind-mounted instead.
+	Mount string
+	cache *InputCache
+	entry *cacheEntry
+}
+
+// Release releases the cached file, allowing it to be evicted.
+// Release must be called when the task is done with a mounted file.
+func (ci *CachedInput) Release() {
+	if ci.entry != nil {
+		ci.cache.release(ci.entry)
+		ci.entry = nil
+	}
+}
+
+// errTooLarge is returned when a file is too large to be cached.
+var errTooLarge = errors.New("file is larger than the input cache")
+
+var (
+	inputCachesMtx sync.Mutex
+	inputCaches    = map[string]*InputCache{}
+)
+
+// OpenInputCache returns the input cache configured by conf.InputCache,
+// or nil if the cache is disabled.
+//
+// Caches are shared by directory within a process, so all the workers
+// started by a node use the same cache.
+func OpenInputCache(conf config.Worker) (*InputCache, error) {
+	if !conf.InputCache.Enabled {
+		return nil, nil
+	}
+
+	dir := conf.InputCache.Dir
+	if dir == "" {
+		dir = filepath.Join(conf.WorkDir, "input-cache")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	inputCachesMtx.Lock()
+	defer inputCachesMtx.Unlock()
+
+	if c, ok := inputCaches[dir]; ok {
+		return c, nil
+	}
+	c, err := newInputCache(dir, conf.InputCache.MaxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input cache: %s", err)
+	}
+	inputCaches[dir] = c
+	return c, nil
+}
+
+func newInputCache(dir string, maxBytes int64) (*InputCache, error) {
+	err := util.EnsureDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &InputCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  map[string]*cacheEntry{},
+		lru:      list.New(),
+		pending:  map[string]chan struct{}{},
+	}
+
+	// Load the files cached by a previous process. The modification time
+	// is updated on each cache hit, so it orders the files by last use.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	for _, info := range infos {
+		p := filepath.Join(dir, info.Name())
+		// Remove partial downloads.
+		if strings.HasPrefix(info.Name(), "tmp-") {
+			os.Remove(p)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		c.add(info.Name(), p, info.Size())
+	}
+	c.evict()
+	return c, nil
+}
+
+// Get makes the file at "url" available at the host path "dest",
+// downloading it into the cache first if needed.
+//
+// An error is returned if the file can't be cached, e.g. if the storage
+// backend doesn't support object versions, in which case the caller should
+// download the file directly.
+func (c *InputCache) Get(ctx context.Context, store storage.Storage, url, dest string) (*CachedInput, error) {
+	version, err := store.Version(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	key := cacheKey(url, version)
+
+	for {
+		c.mtx.Lock()
+
+		if e, ok := c.entries[key]; ok {
+			c.lru.MoveToFront(e.elem)
+			e.refs++
+			c.mtx.Unlock()
+
+			now := time.Now()
+			os.Chtimes(e.path, now, now)
+			return c.provide(e, dest, true)
+		}
+
+		// Another worker is downloading the same file, wait for it.
+		if wait, ok := c.pending[key]; ok {
+			c.mtx.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		done := make(chan struct{})
+		c.pending[key] = done
+		c.mtx.Unlock()
+
+		e, err := c.download(ctx, store, url, key, dest)
+
+		c.mtx.Lock()
+		delete(c.pending, key)
+		close(done)
+		c.mtx.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			// The file was too large to cache, and was moved to "dest".
+			return &CachedInput{}, nil
+		}
+		return c.provide(e, dest, false)
+	}
+}
+
+// download downloads the file at "url" into the cache, and returns the
+// new entry, which has a reference held for the caller.
+//
+// If the file is too large for the cache, it's moved to "dest"
+// and a nil entry is returned.
+func (c *InputCache) download(ctx context.Context, store storage.Storage, url, key, dest string) (*cacheEntry, error) {
+	tmp, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	err = store.Get(ctx, url, tmp.Name(), tes.FileType_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > c.maxBytes {
+		os.Chmod(tmp.Name(), 0644)
+		if os.Rename(tmp.Name(), dest) != nil {
+			return nil, errTooLarge
+		}
+		return nil, nil
+	}
+
+	// Cached files are shared by tasks, so make sure they're not modified.
+	err = os.Chmod(tmp.Name(), 0444)
+	if err != nil {
+		return nil, err
+	}
+
+	p := filepath.Join(c.dir, key)
+	err = os.Rename(tmp.Name(), p)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e := c.add(key, p, info.Size())
+	e.refs++
+	c.evict()
+	return e, nil
+}
+
+// provide hard-links the cached file to "dest". If that fails,
+// the caller must mount the file, so the entry's reference is kept
+// until the CachedInput is released.
+func (c *InputCache) provide(e *cacheEntry, dest string, hit bool) (*CachedInput, error) {
+	os.Remove(dest)
+	err := os.Link(e.path, dest)
+	if err == nil {
+		c.release(e)
+		return &CachedInput{Hit: hit}, nil
+	}
+	return &CachedInput{Hit: hit, Mount: e.path, cache: c, entry: e}, nil
+}
+
+// add adds an entry to the cache. The caller must hold the lock.
+func (c *InputCache) add(key, path string, size int64) *cacheEntry {
+	e := &cacheEntry{key: key, path: path, size: size}
+	e.elem = c.lru.PushFront(e)
+	c.entries[key] = e
+	c.size += size
+	return e
+}
+
+// release releases a reference to an entry, and evicts entries
+// if the cache is over its size limit.
+func (c *InputCache) release(e *cacheEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e.refs--
+	c.evict()
+}
+
+// evict removes the least recently used entries until the cache fits
+// within its size limit. Entries in use are skipped.
+// The caller must hold the lock.
+func (c *InputCache) evict() {
+	el := c.lru.Back()
+	for c.size > c.maxBytes && el != nil {
+		e := el.Value.(*cacheEntry)
+		el = el.Prev()
+		if e.refs > 0 {
+			continue
+		}
+		os.Remove(e.path)
+		c.lru.Remove(e.elem)
+		delete(c.entries, e.key)
+		c.size -= e.size
+	}
+}
+
+// cacheKey returns the name of the cache file for a URL and version.
+func cacheKey(url, version string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(url+"\n"+version)))
+}
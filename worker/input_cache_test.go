@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"context"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/storage"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// fakeVersionedBackend serves files from memory, with a version per file.
+type fakeVersionedBackend struct {
+	files    map[string]string
+	versions map[string]string
+	gets     int
+}
+
+func (f *fakeVersionedBackend) Get(ctx context.Context, url string, path string, class tes.FileType) error {
+	f.gets++
+	return ioutil.WriteFile(path, []byte(f.files[url]), 0644)
+}
+
+func (f *fakeVersionedBackend) PutFile(ctx context.Context, url string, path string) error {
+	return nil
+}
+
+func (f *fakeVersionedBackend) Stat(ctx context.Context, url string) (*storage.Object, error) {
+	content, ok := f.files[url]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &storage.Object{URL: url, Size: int64(len(content)), ETag: f.versions[url]}, nil
+}
+
+func (f *fakeVersionedBackend) List(ctx context.Context, url string) ([]*storage.Object, error) {
+	return nil, nil
+}
+
+func (f *fakeVersionedBackend) Supports(url string, path string, class tes.FileType) bool {
+	return strings.HasPrefix(url, "fake://")
+}
+
+func (f *fakeVersionedBackend) Version(ctx context.Context, url string) (string, error) {
+	return f.versions[url], nil
+}
+
+func TestInputCache(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "funnel-test-input-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	fake := &fakeVersionedBackend{
+		files: map[string]string{
+			"fake://a": "aaaa",
+			"fake://b": "bbbb",
+		},
+		versions: map[string]string{
+			"fake://a": "1",
+			"fake://b": "1",
+		},
+	}
+	store := storage.Storage{}.WithBackend(fake)
+
+	conf := config.Worker{WorkDir: tmp}
+	conf.InputCache.Enabled = true
+	conf.InputCache.MaxBytes = 6
+	cache, err := OpenInputCache(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same, _ := OpenInputCache(conf)
+	if same != cache {
+		t.Error("expected workers to share the input cache")
+	}
+
+	get := func(url, dest string) *CachedInput {
+		c, err := cache.Get(ctx, store, url, path.Join(tmp, dest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Release()
+		b, err := ioutil.ReadFile(path.Join(tmp, dest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != fake.files[url] {
+			t.Errorf("unexpected content for %s: %s", url, string(b))
+		}
+		return c
+	}
+
+	if get("fake://a", "a1").Hit {
+		t.Error("expected a cache miss")
+	}
+	if !get("fake://a", "a2").Hit {
+		t.Error("expected a cache hit")
+	}
+	if fake.gets != 1 {
+		t.Errorf("expected 1 download, got %d", fake.gets)
+	}
+
+	// A new version of the file isn't served from the cache.
+	fake.files["fake://a"] = "AAAA"
+	fake.versions["fake://a"] = "2"
+	if get("fake://a", "a3").Hit {
+		t.Error("expected a cache miss for a new version")
+	}
+
+	// The cache only fits one file, so "a" is evicted.
+	if get("fake://b", "b1").Hit {
+		t.Error("expected a cache miss")
+	}
+	if get("fake://a", "a4").Hit {
+		t.Error("expected an evicted file to miss")
+	}
+	if fake.gets != 4 {
+		t.Errorf("expected 4 downloads, got %d", fake.gets)
+	}
+
+	// A new cache loads the files cached previously.
+	reopened, err := newInputCache(cache.dir, conf.InputCache.MaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := reopened.Get(ctx, store, "fake://a", path.Join(tmp, "a5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Hit {
+		t.Error("expected a cache hit after reopening the cache")
+	}
+}
@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"os"
+	"strconv"
+)
+
+// Task tags which set the network and user options of executor containers.
+const (
+	// networkTag selects the network mode, which must be one of the
+	// worker's AllowedNetworkModes.
+	networkTag = "funnel_network"
+	// runAsHostUserTag runs the containers as the worker's UID/GID,
+	// when the value is "true".
+	runAsHostUserTag = "funnel_run_as_host_user"
+)
+
+// Container network modes, see config.Worker.NetworkMode.
+var networkModes = []string{"none", "bridge", "host"}
+
+// isolatingRuntimes are the container runtimes which can set the network
+// mode and the supplementary groups of the containers.
+var isolatingRuntimes = []string{"", "docker", "podman"}
+
+// isolation holds the network and user options of a task's containers.
+type isolation struct {
+	// network is the network mode. Empty uses the runtime's default.
+	network string
+	// user is the "uid:gid" the containers run as.
+	// Empty uses the image's user.
+	user string
+	// groups are the supplementary groups of the container's user.
+	groups []string
+}
+
+// getIsolation gets the network and user options of a task's containers
+// from the task's tags, falling back to the defaults in the worker config.
+//
+// Tasks may select a network mode from conf.AllowedNetworkModes, and may
+// enable running as the host user, but not disable it.
+//
+// The singularity and exec runtimes can't enforce a network mode or
+// supplementary groups, so an error is returned if these are set, rather
+// than running the executors without them. These runtimes always run
+// the executors as the worker's user, so running as the host user is
+// supported.
+func getIsolation(task *tes.Task, conf config.Worker) (isolation, error) {
+	iso := isolation{
+		network: conf.NetworkMode,
+		groups:  conf.SupplementaryGroups,
+	}
+	if iso.network != "" && !contains(networkModes, iso.network) {
+		return iso, fmt.Errorf("unknown NetworkMode: %s", iso.network)
+	}
+
+	tags := task.GetTags()
+	if mode, ok := tags[networkTag]; ok && mode != iso.network {
+		if !contains(conf.AllowedNetworkModes, mode) {
+			return iso, fmt.Errorf("invalid %s tag %q: allowed modes are %v", networkTag, mode, conf.AllowedNetworkModes)
+		}
+		iso.network = mode
+	}
+
+	runAsHost := conf.RunAsHostUser
+	if v, ok := tags[runAsHostUserTag]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return iso, fmt.Errorf("invalid %s tag %q: expected true or false", runAsHostUserTag, v)
+		}
+		runAsHost = runAsHost || b
+	}
+	if runAsHost {
+		iso.user = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+
+	if !contains(isolatingRuntimes, conf.ContainerRuntime) {
+		if iso.network != "" {
+			return iso, fmt.Errorf("network mode %q isn't supported by the %s runtime", iso.network, conf.ContainerRuntime)
+		}
+		if len(iso.groups) > 0 {
+			return iso, fmt.Errorf("SupplementaryGroups aren't supported by the %s runtime", conf.ContainerRuntime)
+		}
+	}
+	return iso, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"os"
+	"testing"
+)
+
+func TestGetIsolation(t *testing.T) {
+	conf := config.Worker{
+		AllowedNetworkModes: []string{"none", "bridge"},
+		SupplementaryGroups: []string{"data"},
+	}
+	hostUser := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+
+	iso, err := getIsolation(&tes.Task{}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iso.network != "" || iso.user != "" || len(iso.groups) != 1 {
+		t.Error("unexpected defaults", iso)
+	}
+
+	iso, err = getIsolation(&tes.Task{
+		Tags: map[string]string{
+			networkTag:       "none",
+			runAsHostUserTag: "true",
+		},
+	}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iso.network != "none" || iso.user != hostUser {
+		t.Error("unexpected task options", iso)
+	}
+
+	// Tasks can't select a network mode which isn't allowed.
+	_, err = getIsolation(&tes.Task{
+		Tags: map[string]string{networkTag: "host"},
+	}, conf)
+	if err == nil {
+		t.Error("expected error for a network mode which isn't allowed")
+	}
+
+	// Tasks can't disable running as the host user.
+	conf.RunAsHostUser = true
+	iso, err = getIsolation(&tes.Task{
+		Tags: map[string]string{runAsHostUserTag: "false"},
+	}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iso.user != hostUser {
+		t.Error("expected the host user", iso)
+	}
+
+	_, err = getIsolation(&tes.Task{
+		Tags: map[string]string{runAsHostUserTag: "maybe"},
+	}, conf)
+	if err == nil {
+		t.Error("expected error for an invalid tag")
+	}
+
+	conf.NetworkMode = "overlay"
+	_, err = getIsolation(&tes.Task{}, conf)
+	if err == nil {
+		t.Error("expected error for an unknown network mode")
+	}
+}
+
+// Tests that options which the runtime can't enforce aren't ignored.
+func TestGetIsolationRuntime(t *testing.T) {
+	conf := config.Worker{
+		ContainerRuntime:    "singularity",
+		AllowedNetworkModes: []string{"none"},
+	}
+
+	_, err := getIsolation(&tes.Task{
+		Tags: map[string]string{networkTag: "none"},
+	}, conf)
+	if err == nil {
+		t.Error("expected error for a network mode with the singularity runtime")
+	}
+
+	conf.ContainerRuntime = "exec"
+	conf.SupplementaryGroups = []string{"data"}
+	_, err = getIsolation(&tes.Task{}, conf)
+	if err == nil {
+		t.Error("expected error for supplementary groups with the exec runtime")
+	}
+
+	conf.SupplementaryGroups = nil
+	iso, err := getIsolation(&tes.Task{
+		Tags: map[string]string{runAsHostUserTag: "true"},
+	}, conf)
+	if err != nil {
+		t.Error("expected running as the host user to be supported", err)
+	}
+	if iso.user == "" {
+		t.Error("expected the host user", iso)
+	}
+}
@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"github.com/ohsu-comp-bio/funnel/util"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// logArchiveDir is the directory in the task's working directory where
+// the full executor logs are written before they are uploaded.
+const logArchiveDir = ".funnel-logs"
+
+// logArchive holds the files which collect the full stdout/stderr
+// of an executor, see config.Worker.ExecutorLogsURL.
+type logArchive struct {
+	taskID string
+	index  int
+	stdout *os.File
+	stderr *os.File
+}
+
+// executorLogsMetadata returns the task log metadata keys where the URLs of
+// an executor's archived stdout/stderr are recorded.
+//
+// The TES ExecutorLog doesn't have a field for these URLs, so they are stored
+// in the task log's metadata, under a key per executor,
+// e.g. "executor_0_stdout_url".
+func executorLogsMetadata(index int) (stdout, stderr string) {
+	return fmt.Sprintf("executor_%d_stdout_url", index),
+		fmt.Sprintf("executor_%d_stderr_url", index)
+}
+
+// executorLogsURL returns the URL where an executor's stdout or stderr
+// is archived.
+func executorLogsURL(prefix, taskID string, attempt uint32, index int, stream string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return fmt.Sprintf("%s/%s/%d/executor-%d.%s", prefix, taskID, attempt, index, stream)
+}
+
+// openLogArchive creates the files which collect the full stdout/stderr of
+// the executor at the given index, and adds them to the step's
+// stdout/stderr writers.
+//
+// If log archiving isn't configured, nil is returned.
+func (r *DefaultWorker) openLogArchive(s *stepWorker, taskID string, index int) (*logArchive, error) {
+	if r.Conf.ExecutorLogsURL == "" {
+		return nil, nil
+	}
+
+	dir := filepath.Join(r.Mapper.dir, logArchiveDir)
+	err := util.EnsureDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &logArchive{taskID: taskID, index: index}
+	a.stdout, err = os.Create(filepath.Join(dir, fmt.Sprintf("executor-%d.stdout", index)))
+	if err != nil {
+		return nil, err
+	}
+	a.stderr, err = os.Create(filepath.Join(dir, fmt.Sprintf("executor-%d.stderr", index)))
+	if err != nil {
+		a.stdout.Close()
+		return nil, err
+	}
+
+	s.Container.Stdout = teeWriter(s.Container.Stdout, a.stdout)
+	s.Container.Stderr = teeWriter(s.Container.Stderr, a.stderr)
+	return a, nil
+}
+
+// upload closes the archive's files and uploads them to the configured
+// storage URL prefix. The URLs are recorded in the task log metadata.
+//
+// Archived logs are a convenience, so a failed upload is logged
+// but doesn't fail the task.
+func (r *DefaultWorker) uploadLogArchive(ctx context.Context, a *logArchive) {
+	if a == nil {
+		return
+	}
+	a.stdout.Close()
+	a.stderr.Close()
+
+	attempt := r.Event.Attempt()
+	stdoutKey, stderrKey := executorLogsMetadata(a.index)
+	meta := map[string]string{}
+
+	for _, f := range []struct {
+		key, stream, path string
+	}{
+		{stdoutKey, "stdout", a.stdout.Name()},
+		{stderrKey, "stderr", a.stderr.Name()},
+	} {
+		url := executorLogsURL(r.Conf.ExecutorLogsURL, a.taskID, attempt, a.index, f.stream)
+		_, err := r.Store.Put(ctx, url, f.path, tes.FileType_FILE)
+		if err != nil {
+			r.Event.Error("Couldn't archive executor logs", "url", url, "error", err)
+			continue
+		}
+		meta[f.key] = url
+	}
+
+	if len(meta) > 0 {
+		r.Event.Metadata(meta)
+	}
+}
+
+// teeWriter returns a writer which writes to both "w" and "f".
+// "w" may be nil.
+func teeWriter(w io.Writer, f io.Writer) io.Writer {
+	if w == nil {
+		return f
+	}
+	return io.MultiWriter(w, f)
+}
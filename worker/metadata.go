@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/version"
+	"net"
+	"os"
+	"time"
+)
+
+// hostMetadata returns the task log metadata which describes the host
+// running the task: its hostname, IP address, node ID (if the worker was
+// started by a Funnel node) and the Funnel version.
+func hostMetadata(conf config.Worker) map[string]string {
+	meta := map[string]string{
+		"funnel_version": version.Version,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		meta["hostname"] = hostname
+	}
+	if ip, err := externalIP(); err == nil {
+		meta["ip"] = ip
+	}
+	if conf.NodeID != "" {
+		meta["node_id"] = conf.NodeID
+	}
+	return meta
+}
+
+// externalIP returns the first IPv4 address of the host's network
+// interfaces which are up, ignoring loopback interfaces.
+func externalIP() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			}
+			if ip = ip.To4(); ip != nil && !ip.IsLoopback() {
+				return ip.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no external IP address found")
+}
+
+// stage records the start time of a task stage, e.g. "download", in the
+// task log metadata, as "<stage>_start_time". The returned function records
+// the stage's end time, as "<stage>_end_time".
+func (r *DefaultWorker) stage(name string) func() {
+	r.Event.Metadata(map[string]string{
+		name + "_start_time": time.Now().Format(time.RFC3339Nano),
+	})
+	return func() {
+		r.Event.Metadata(map[string]string{
+			name + "_end_time": time.Now().Format(time.RFC3339Nano),
+		})
+	}
+}
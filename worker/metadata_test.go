@@ -0,0 +1,27 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/version"
+	"testing"
+)
+
+func TestHostMetadata(t *testing.T) {
+	conf := config.Worker{NodeID: "node-1"}
+	meta := hostMetadata(conf)
+
+	if meta["funnel_version"] != version.Version {
+		t.Error("unexpected funnel_version", meta["funnel_version"])
+	}
+	if meta["hostname"] == "" {
+		t.Error("missing hostname")
+	}
+	if meta["node_id"] != "node-1" {
+		t.Error("unexpected node_id", meta["node_id"])
+	}
+
+	meta = hostMetadata(config.Worker{})
+	if _, ok := meta["node_id"]; ok {
+		t.Error("expected no node_id for a worker without a node")
+	}
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Task tags which control how outputs are uploaded.
+// Values are "true" (all outputs), "false" (no outputs), or a comma-separated
+// list of output paths, as in the task's outputs, e.g. "/outputs/log.txt".
+const (
+	// optionalOutputsTag marks outputs as optional. An optional output
+	// which doesn't exist, or a glob which matches nothing, is skipped
+	// instead of failing the task.
+	optionalOutputsTag = "funnel_optional_outputs"
+	// uploadOnFailureTag uploads outputs even when an executor failed,
+	// e.g. logs and partial results for debugging.
+	uploadOnFailureTag = "funnel_upload_on_failure"
+)
+
+// outputSet is a set of a task's outputs, by container path.
+type outputSet struct {
+	all   bool
+	paths map[string]bool
+}
+
+// contains returns true if the set contains the output at the given
+// container path.
+func (s outputSet) contains(p string) bool {
+	return s.all || s.paths[path.Clean(p)]
+}
+
+// empty returns true if the set doesn't contain any outputs.
+func (s outputSet) empty() bool {
+	return !s.all && len(s.paths) == 0
+}
+
+// outputPolicy holds the options for uploading a task's outputs.
+type outputPolicy struct {
+	// optional outputs are skipped if they don't exist.
+	optional outputSet
+	// onFailure outputs are uploaded even if an executor failed.
+	onFailure outputSet
+}
+
+// getOutputPolicy gets the options for uploading the task's outputs from
+// the task's tags, falling back to the defaults in the worker config.
+func getOutputPolicy(task *tes.Task, conf config.Worker) (outputPolicy, error) {
+	var p outputPolicy
+	var err error
+
+	p.optional, err = parseOutputSetTag(task, optionalOutputsTag, false)
+	if err != nil {
+		return p, err
+	}
+
+	p.onFailure, err = parseOutputSetTag(task, uploadOnFailureTag, conf.UploadOutputsOnFailure)
+	return p, err
+}
+
+// parseOutputSetTag parses a tag which selects a set of the task's outputs.
+// If the task doesn't have the tag, the set contains all outputs if "def"
+// is true.
+func parseOutputSetTag(task *tes.Task, key string, def bool) (outputSet, error) {
+	s := outputSet{all: def}
+
+	v, ok := task.GetTags()[key]
+	if !ok {
+		return s, nil
+	}
+	if b, err := strconv.ParseBool(v); err == nil {
+		s.all = b
+		return s, nil
+	}
+
+	s.all = false
+	s.paths = map[string]bool{}
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		p = path.Clean(p)
+		if !hasOutput(task, p) {
+			return s, fmt.Errorf("invalid %s tag %q: %s is not an output path of the task", key, v, p)
+		}
+		s.paths[p] = true
+	}
+	return s, nil
+}
+
+// hasOutput returns true if the task has an output at the given path.
+func hasOutput(task *tes.Task, p string) bool {
+	for _, o := range task.GetOutputs() {
+		if path.Clean(o.Path) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// failureOutputs returns the task's mapped outputs which are uploaded
+// even if an executor failed.
+func (r *DefaultWorker) failureOutputs(p outputPolicy) []*tes.Output {
+	var outputs []*tes.Output
+	for _, output := range r.Mapper.Outputs {
+		if p.onFailure.contains(r.Mapper.ContainerPath(output.Path)) {
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"testing"
+)
+
+func outputsTask(tags map[string]string) *tes.Task {
+	return &tes.Task{
+		Outputs: []*tes.Output{
+			{Path: "/outputs/log.txt"},
+			{Path: "/outputs/results/*.bam"},
+		},
+		Tags: tags,
+	}
+}
+
+func TestOutputPolicyDefaults(t *testing.T) {
+	p, err := getOutputPolicy(outputsTask(nil), config.Worker{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.optional.empty() || !p.onFailure.empty() {
+		t.Error("expected no optional outputs and no uploads on failure")
+	}
+
+	p, err = getOutputPolicy(outputsTask(nil), config.Worker{UploadOutputsOnFailure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.onFailure.contains("/outputs/log.txt") {
+		t.Error("expected all outputs to be uploaded on failure")
+	}
+}
+
+func TestOutputPolicyTags(t *testing.T) {
+	p, err := getOutputPolicy(outputsTask(map[string]string{
+		optionalOutputsTag: "/outputs/results/*.bam",
+		uploadOnFailureTag: " /outputs/log.txt ",
+	}), config.Worker{UploadOutputsOnFailure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.optional.contains("/outputs/results/*.bam") || p.optional.contains("/outputs/log.txt") {
+		t.Error("unexpected optional outputs", p.optional)
+	}
+	if !p.onFailure.contains("/outputs/log.txt") || p.onFailure.contains("/outputs/results/*.bam") {
+		t.Error("unexpected outputs uploaded on failure", p.onFailure)
+	}
+
+	p, err = getOutputPolicy(outputsTask(map[string]string{
+		uploadOnFailureTag: "false",
+	}), config.Worker{UploadOutputsOnFailure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.onFailure.empty() {
+		t.Error("expected the tag to disable uploads on failure")
+	}
+}
+
+func TestOutputPolicyUnknownPath(t *testing.T) {
+	_, err := getOutputPolicy(outputsTask(map[string]string{
+		optionalOutputsTag: "/outputs/other.txt",
+	}), config.Worker{})
+	if err == nil {
+		t.Fatal("expected error for a path which isn't an output of the task")
+	}
+}
@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PodmanCommand is responsible for configuring and running a podman container.
+// Podman's CLI is compatible with docker's, so the run arguments are shared.
+type PodmanCommand struct {
+	ContainerConfig
+}
+
+// Run runs the podman command and blocks until done.
+func (pcmd *PodmanCommand) Run(ctx context.Context) error {
+	err := pcmd.pull(ctx)
+	if err != nil {
+		return &systemError{err}
+	}
+	pcmd.recordDigest()
+
+	// The task may have been canceled while the image was pulled.
+	// Stop doesn't stop a container which hasn't started yet.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The container is removed after it has been inspected,
+	// so that an out-of-memory kill can be detected.
+	conf := pcmd.ContainerConfig
+	conf.RemoveContainer = false
+	envFile, err := writeEnvFile(conf)
+	if err != nil {
+		return &systemError{err}
+	}
+	if envFile != "" {
+		defer os.Remove(envFile)
+	}
+	args := runArgs(conf, envFile)
+
+	pcmd.Event.Info("Running command", "cmd", "podman "+strings.Join(args, " "))
+	cmd := exec.Command("podman", args...)
+	setStdio(cmd, pcmd.ContainerConfig)
+	err = cmd.Run()
+
+	if code := getExitCode(err); code > 0 && pcmd.oomKilled() {
+		pcmd.Event.Error("Container was killed because it ran out of memory", "exit code", code)
+		err = &exitError{code: code, oomKilled: true}
+	}
+
+	if pcmd.RemoveContainer {
+		exec.Command("podman", "rm", "-f", pcmd.Name).Run()
+	}
+	return err
+}
+
+// Stop stops the container.
+func (pcmd *PodmanCommand) Stop() error {
+	pcmd.Event.Info("Stopping container", "container", pcmd.Name)
+	return exec.Command("podman", "stop", "-t", "10", pcmd.Name).Run()
+}
+
+// Pause freezes the container's processes.
+func (pcmd *PodmanCommand) Pause() error {
+	return exec.Command("podman", "pause", pcmd.Name).Run()
+}
+
+// Resume unfreezes the container's processes.
+func (pcmd *PodmanCommand) Resume() error {
+	return exec.Command("podman", "unpause", pcmd.Name).Run()
+}
+
+// oomKilled returns true if podman reports the container was killed
+// because it exceeded its memory limit.
+func (pcmd *PodmanCommand) oomKilled() bool {
+	out, err := exec.Command("podman", "inspect", "--format", "{{.State.OOMKilled}}", pcmd.Name).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// pull pulls the container image according to the pull policy.
+// The pull is killed if the context is done.
+func (pcmd *PodmanCommand) pull(ctx context.Context) error {
+	switch pcmd.PullPolicy {
+	case "", pullAlways:
+	case pullIfNotPresent, pullNever:
+		if exec.Command("podman", "image", "exists", pcmd.Image).Run() == nil {
+			pcmd.Event.Info("Using local image", "image", pcmd.Image, "pull policy", pcmd.PullPolicy)
+			return nil
+		}
+		if pcmd.PullPolicy == pullNever {
+			err := fmt.Errorf("image not found: %s: the pull policy is %q", pcmd.Image, pullNever)
+			pcmd.Event.Error("Failed to find image", "image", pcmd.Image, "error", err)
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown pull policy: %s", pcmd.PullPolicy)
+	}
+
+	args := []string{"pull", "--quiet"}
+	authfile, err := pcmd.RegistryAuth.writeAuthFile(pcmd.Image)
+	if err != nil {
+		return err
+	}
+	if authfile != "" {
+		defer os.Remove(authfile)
+		args = append(args, "--authfile", authfile)
+	}
+	args = append(args, pcmd.Image)
+
+	pcmd.Event.Info("Pulling image", "image", pcmd.Image)
+	out, err := exec.CommandContext(ctx, "podman", args...).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+		pcmd.Event.Error("Failed to pull image", "image", pcmd.Image, "error", err)
+		return err
+	}
+	return nil
+}
+
+// recordDigest resolves the digest of the container image,
+// so that the exact image which ran is recorded.
+func (pcmd *PodmanCommand) recordDigest() {
+	if pcmd.ImageDigest == nil {
+		return
+	}
+	out, err := exec.Command("podman", "image", "inspect", "--format", "{{json .}}", pcmd.Image).Output()
+	var info types.ImageInspect
+	if err == nil {
+		err = json.Unmarshal(out, &info)
+	}
+	if err != nil {
+		pcmd.Event.Error("Failed to resolve image digest", "image", pcmd.Image, "error", err)
+		return
+	}
+	digest := imageDigest(pcmd.Image, info)
+	pcmd.Event.Info("Resolved image digest", "image", pcmd.Image, "digest", digest)
+	pcmd.ImageDigest(digest)
+}
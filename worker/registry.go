@@ -0,0 +1,265 @@
+package worker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Image pull policies, see config.Worker.PullPolicy.
+const (
+	pullAlways       = "always"
+	pullIfNotPresent = "if-not-present"
+	pullNever        = "never"
+)
+
+// registryAuthTag is the task tag which selects a per-task credentials file
+// from config.RegistryAuth.SecretsDir.
+const registryAuthTag = "funnel_registry_auth"
+
+// dockerHub is the registry of images which don't name a registry,
+// e.g. "alpine" or "ohsucompbio/funnel".
+const dockerHub = "docker.io"
+
+// RegistryCredentials maps registry hosts to the credentials
+// used to pull images from them.
+type RegistryCredentials map[string]types.AuthConfig
+
+// dockerConfigFile is the subset of the docker config file format
+// (e.g. ~/.docker/config.json) which holds registry credentials.
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		// Auth is the base64 encoded "username:password".
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+}
+
+// loadRegistryCredentials loads credentials from a docker config file.
+func loadRegistryCredentials(path string) (RegistryCredentials, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRegistryCredentials(b, path)
+}
+
+// parseRegistryCredentials parses credentials in the docker config file
+// format. "source" describes where the credentials came from, for errors.
+func parseRegistryCredentials(b []byte, source string) (RegistryCredentials, error) {
+	var f dockerConfigFile
+	err := json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing docker config %s: %s", source, err)
+	}
+
+	creds := RegistryCredentials{}
+	for registry, a := range f.Auths {
+		auth := types.AuthConfig{
+			Username:      a.Username,
+			Password:      a.Password,
+			IdentityToken: a.IdentityToken,
+			ServerAddress: registry,
+		}
+		if a.Auth != "" {
+			dec, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("decoding credentials for %s: %s", registry, err)
+			}
+			parts := strings.SplitN(string(dec), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid credentials for %s: expected username:password", registry)
+			}
+			auth.Username, auth.Password = parts[0], parts[1]
+		}
+		creds[normalizeRegistry(registry)] = auth
+	}
+	return creds, nil
+}
+
+// getRegistryCredentials returns the registry credentials of a task:
+// the credentials from conf.ConfigFile, overridden by the task's
+// credentials, if the task has a "funnel_registry_auth" tag.
+//
+// The tag either names a file in conf.SecretsDir, or references a secret,
+// e.g. "secret://registry-auth", holding credentials in the docker config
+// file format.
+func getRegistryCredentials(task *tes.Task, conf config.RegistryAuth, secrets SecretProvider) (RegistryCredentials, error) {
+	creds := RegistryCredentials{}
+
+	if conf.ConfigFile != "" {
+		c, err := loadRegistryCredentials(conf.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range c {
+			creds[k] = v
+		}
+	}
+
+	name := task.GetTags()[registryAuthTag]
+	if name == "" {
+		return creds, nil
+	}
+
+	var c RegistryCredentials
+	var err error
+	if secret, ok := secretName(name); ok {
+		if secrets == nil {
+			return nil, fmt.Errorf("%s tag references secret %q, but no secret provider is configured", registryAuthTag, secret)
+		}
+		var val string
+		val, err = secrets.Secret(secret)
+		if err != nil {
+			return nil, err
+		}
+		c, err = parseRegistryCredentials([]byte(val), "")
+		if err != nil {
+			// Don't include the parse error, which may quote the secret.
+			err = fmt.Errorf("secret %q isn't a valid docker config", secret)
+		}
+	} else {
+		if conf.SecretsDir == "" {
+			return nil, fmt.Errorf("task has a %s tag, but RegistryAuth.SecretsDir isn't configured", registryAuthTag)
+		}
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("invalid %s tag: %s", registryAuthTag, name)
+		}
+		c, err = loadRegistryCredentials(filepath.Join(conf.SecretsDir, name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c {
+		creds[k] = v
+	}
+	return creds, nil
+}
+
+// forImage returns the base64 encoded credentials for the image's registry,
+// in the format expected by the Docker Engine API. If there are no
+// credentials for the registry, an empty string is returned.
+func (rc RegistryCredentials) forImage(image string) (string, error) {
+	auth, ok := rc[imageRegistry(image)]
+	if !ok {
+		return "", nil
+	}
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// writeAuthFile writes the credentials for the image's registry to a
+// temporary docker config file, for CLIs such as podman. The caller must
+// remove the file. If there are no credentials for the registry,
+// an empty path is returned.
+func (rc RegistryCredentials) writeAuthFile(image string) (string, error) {
+	registry := imageRegistry(image)
+	auth, ok := rc[registry]
+	if !ok {
+		return "", nil
+	}
+
+	entry := map[string]string{}
+	if auth.Username != "" || auth.Password != "" {
+		entry["auth"] = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+	}
+	if auth.IdentityToken != "" {
+		entry["identitytoken"] = auth.IdentityToken
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{registry: entry},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile("", "funnel-auth-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = f.Write(b)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// imageRegistry returns the registry host of an image name,
+// e.g. "quay.io/biocontainers/samtools:1.6" returns "quay.io".
+func imageRegistry(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return dockerHub
+	}
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return dockerHub
+	}
+	return normalizeRegistry(host)
+}
+
+// normalizeRegistry converts a registry address, as found in a docker
+// config file, to a registry host, e.g. "https://index.docker.io/v1/"
+// returns "docker.io".
+func normalizeRegistry(registry string) string {
+	r := strings.TrimPrefix(registry, "https://")
+	r = strings.TrimPrefix(r, "http://")
+	if i := strings.Index(r, "/"); i != -1 {
+		r = r[:i]
+	}
+	switch r {
+	case "index.docker.io", "registry-1.docker.io":
+		return dockerHub
+	}
+	return r
+}
+
+// imageRepository returns an image name without its tag or digest,
+// e.g. "quay.io/biocontainers/samtools:1.6" returns "quay.io/biocontainers/samtools".
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// imageDigest returns the digest of an image, e.g. "alpine@sha256:...",
+// preferring the repository digest which matches the image name.
+// If the image has no repository digest, e.g. it was built locally,
+// the image ID is returned.
+func imageDigest(image string, info types.ImageInspect) string {
+	repo := imageRepository(image)
+	for _, d := range info.RepoDigests {
+		if strings.HasPrefix(d, repo+"@") {
+			return d
+		}
+	}
+	if len(info.RepoDigests) > 0 {
+		return info.RepoDigests[0]
+	}
+	return info.ID
+}
+
+// imageDigestMetadata returns the task log metadata key where the digest
+// of the image used by the executor at the given index is recorded,
+// e.g. "executor_0_image_digest".
+func imageDigestMetadata(index int) string {
+	return fmt.Sprintf("executor_%d_image_digest", index)
+}
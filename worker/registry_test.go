@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageRegistry(t *testing.T) {
+	tests := map[string]string{
+		"alpine":                             "docker.io",
+		"ohsucompbio/funnel:latest":          "docker.io",
+		"docker.io/library/alpine":           "docker.io",
+		"quay.io/biocontainers/samtools:1.6": "quay.io",
+		"localhost:5000/tool":                "localhost:5000",
+		"localhost/tool":                     "localhost",
+	}
+	for image, expected := range tests {
+		if r := imageRegistry(image); r != expected {
+			t.Errorf("imageRegistry(%q): expected %q, got %q", image, expected, r)
+		}
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	info := types.ImageInspect{
+		ID: "sha256:abc",
+		RepoDigests: []string{
+			"other/alpine@sha256:111",
+			"alpine@sha256:222",
+		},
+	}
+	if d := imageDigest("alpine:3.6", info); d != "alpine@sha256:222" {
+		t.Error("unexpected digest", d)
+	}
+	if d := imageDigest("mirror/alpine", info); d != "other/alpine@sha256:111" {
+		t.Error("unexpected digest", d)
+	}
+	if d := imageDigest("local", types.ImageInspect{ID: "sha256:abc"}); d != "sha256:abc" {
+		t.Error("unexpected digest", d)
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "funnel-test-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	auth := func(user, pass string) string {
+		return base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+	}
+	ioutil.WriteFile(filepath.Join(tmp, "config.json"), []byte(`{
+	  "auths": {
+	    "https://index.docker.io/v1/": {"auth": "`+auth("hub", "hubpass")+`"},
+	    "quay.io": {"auth": "`+auth("node", "nodepass")+`"}
+	  }
+	}`), 0600)
+	secrets := filepath.Join(tmp, "secrets")
+	os.Mkdir(secrets, 0700)
+	ioutil.WriteFile(filepath.Join(secrets, "lab"), []byte(`{
+	  "auths": {
+	    "quay.io": {"username": "lab", "password": "labpass"}
+	  }
+	}`), 0600)
+
+	conf := config.RegistryAuth{
+		ConfigFile: filepath.Join(tmp, "config.json"),
+		SecretsDir: secrets,
+	}
+
+	creds, err := getRegistryCredentials(&tes.Task{}, conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds["docker.io"].Username != "hub" || creds["docker.io"].Password != "hubpass" {
+		t.Error("unexpected docker hub credentials", creds["docker.io"])
+	}
+	if creds["quay.io"].Username != "node" {
+		t.Error("unexpected quay.io credentials", creds["quay.io"])
+	}
+
+	// Per-task credentials override the node's credentials.
+	task := &tes.Task{Tags: map[string]string{registryAuthTag: "lab"}}
+	creds, err = getRegistryCredentials(task, conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds["quay.io"].Username != "lab" || creds["quay.io"].Password != "labpass" {
+		t.Error("unexpected per-task credentials", creds["quay.io"])
+	}
+	if creds["docker.io"].Username != "hub" {
+		t.Error("expected node credentials for other registries")
+	}
+
+	enc, err := creds.forImage("quay.io/biocontainers/samtools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := base64.URLEncoding.DecodeString(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a types.AuthConfig
+	json.Unmarshal(b, &a)
+	if a.Username != "lab" || a.Password != "labpass" {
+		t.Error("unexpected encoded credentials", a)
+	}
+	if enc, _ := creds.forImage("gcr.io/tool"); enc != "" {
+		t.Error("expected no credentials for unknown registry")
+	}
+
+	task.Tags[registryAuthTag] = "../config.json"
+	_, err = getRegistryCredentials(task, conf, nil)
+	if err == nil {
+		t.Error("expected error for a tag outside the secrets dir")
+	}
+}
@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// usageTracker aggregates samples of an executor's resource usage.
+type usageTracker struct {
+	mtx     sync.Mutex
+	samples int
+	cpuSum  float64
+	cpuPeak float64
+	memPeak uint64
+	read    uint64
+	write   uint64
+}
+
+// add adds a sample. "cpu" is the CPU usage in cores, "mem" is the current
+// memory usage in bytes, and "read"/"write" are the total bytes read/written
+// so far.
+func (u *usageTracker) add(cpu float64, mem, read, write uint64) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.samples++
+	u.cpuSum += cpu
+	if cpu > u.cpuPeak {
+		u.cpuPeak = cpu
+	}
+	if mem > u.memPeak {
+		u.memPeak = mem
+	}
+	if read > u.read {
+		u.read = read
+	}
+	if write > u.write {
+		u.write = write
+	}
+}
+
+// usage returns the aggregated resource usage, or nil if there are no samples.
+func (u *usageTracker) usage() *events.ResourceUsage {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	if u.samples == 0 {
+		return nil
+	}
+	return &events.ResourceUsage{
+		CpuPeak:      u.cpuPeak,
+		CpuAvg:       u.cpuSum / float64(u.samples),
+		MemPeakBytes: u.memPeak,
+		ReadBytes:    u.read,
+		WriteBytes:   u.write,
+	}
+}
+
+// trackDocker samples the stats of a docker container until the container
+// exits or the context is canceled.
+func (u *usageTracker) trackDocker(ctx context.Context, dclient *client.Client, id string) error {
+	stats, err := dclient.ContainerStats(ctx, id, true)
+	if err != nil {
+		return err
+	}
+	defer stats.Body.Close()
+
+	dec := json.NewDecoder(stats.Body)
+	for {
+		var s types.StatsJSON
+		if err := dec.Decode(&s); err != nil {
+			return err
+		}
+		// The first sample doesn't have previous CPU stats to compare with.
+		if s.PreCPUStats.SystemUsage == 0 {
+			continue
+		}
+		u.add(dockerCPU(&s), dockerMem(&s), dockerIO(&s, "read"), dockerIO(&s, "write"))
+	}
+}
+
+// dockerCPU returns the CPU usage in cores.
+func dockerCPU(s *types.StatsJSON) float64 {
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	sysDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || sysDelta <= 0 {
+		return 0
+	}
+	ncpu := float64(s.CPUStats.OnlineCPUs)
+	if ncpu == 0 {
+		ncpu = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / sysDelta * ncpu
+}
+
+// dockerMem returns the resident memory usage in bytes, i.e. excluding the
+// page cache.
+func dockerMem(s *types.StatsJSON) uint64 {
+	// "rss" is reported by cgroups v1, "anon" by cgroups v2.
+	if rss, ok := s.MemoryStats.Stats["rss"]; ok {
+		return rss
+	}
+	if anon, ok := s.MemoryStats.Stats["anon"]; ok {
+		return anon
+	}
+	return s.MemoryStats.Usage
+}
+
+// dockerIO returns the total bytes for the given block I/O operation,
+// e.g. "read" or "write".
+func dockerIO(s *types.StatsJSON, op string) uint64 {
+	var total uint64
+	for _, e := range s.BlkioStats.IoServiceBytesRecursive {
+		if strings.ToLower(e.Op) == op {
+			total += e.Value
+		}
+	}
+	return total
+}
+
+// rusageUsage returns the resource usage of an exited process, as reported
+// by the operating system. The peak CPU usage isn't available, so only the
+// average (over the given wall-clock duration) is set.
+func rusageUsage(state *os.ProcessState, wall time.Duration) *events.ResourceUsage {
+	if state == nil || wall <= 0 {
+		return nil
+	}
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return nil
+	}
+
+	cpu := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	// Linux reports maxrss in kilobytes, darwin in bytes.
+	maxrss := uint64(ru.Maxrss)
+	if runtime.GOOS == "linux" {
+		maxrss *= 1024
+	}
+	// Block I/O is counted in 512 byte blocks.
+	return &events.ResourceUsage{
+		CpuAvg:       cpu.Seconds() / wall.Seconds(),
+		MemPeakBytes: maxrss,
+		ReadBytes:    uint64(ru.Inblock) * 512,
+		WriteBytes:   uint64(ru.Oublock) * 512,
+	}
+}
@@ -0,0 +1,27 @@
+package worker
+
+import (
+	"testing"
+)
+
+func TestUsageTracker(t *testing.T) {
+	u := &usageTracker{}
+	if u.usage() != nil {
+		t.Error("expected nil usage when there are no samples")
+	}
+
+	u.add(1, 100, 10, 0)
+	u.add(3, 300, 20, 5)
+	u.add(2, 200, 20, 5)
+
+	r := u.usage()
+	if r.CpuPeak != 3 || r.CpuAvg != 2 {
+		t.Errorf("unexpected cpu usage: peak %f avg %f", r.CpuPeak, r.CpuAvg)
+	}
+	if r.MemPeakBytes != 300 {
+		t.Errorf("unexpected peak memory: %d", r.MemPeakBytes)
+	}
+	if r.ReadBytes != 20 || r.WriteBytes != 5 {
+		t.Errorf("unexpected io: read %d write %d", r.ReadBytes, r.WriteBytes)
+	}
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"context"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"time"
+)
+
+// retry puts the task back in the queue, if the retry policy allows another
+// attempt after the task ended in the given state. The worker waits for the
+// backoff before requeueing the task. retry returns false if the task
+// isn't retried, in which case the caller should set the final state.
+func (r *DefaultWorker) retry(ctx context.Context, state tes.State) bool {
+	policy := r.Conf.Retry
+	attempt := r.Event.Attempt()
+
+	if !retryable(policy, state) || attempt+1 >= policy.MaxAttempts {
+		return false
+	}
+
+	wait := backoff(policy, attempt)
+	r.Event.Info("Retrying task",
+		"state", state.String(),
+		"attempt", attempt+1,
+		"maxAttempts", policy.MaxAttempts,
+		"backoff", wait.String(),
+	)
+
+	// If the worker is shutting down, requeue the task without waiting,
+	// so it can be picked up by another node.
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+
+	err := r.Event.Requeue()
+	if err != nil {
+		r.Event.Error("Couldn't requeue task", "error", err)
+		return false
+	}
+	return true
+}
+
+// retryable returns true if the retry policy retries tasks
+// which end in the given state.
+func retryable(policy config.RetryPolicy, state tes.State) bool {
+	// Completed and canceled tasks are never retried.
+	if state == tes.State_COMPLETE || state == tes.State_CANCELED {
+		return false
+	}
+	return policy.Retries(state.String())
+}
+
+// backoff returns how long to wait before requeueing the given attempt.
+// The wait doubles with each attempt, up to policy.MaxBackoff.
+func backoff(policy config.RetryPolicy, attempt uint32) time.Duration {
+	wait := policy.Backoff
+	for i := uint32(0); i < attempt; i++ {
+		wait *= 2
+		if policy.MaxBackoff > 0 && wait >= policy.MaxBackoff {
+			break
+		}
+	}
+	if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+		wait = policy.MaxBackoff
+	}
+	return wait
+}
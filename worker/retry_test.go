@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	policy := config.RetryPolicy{
+		Backoff:    time.Second,
+		MaxBackoff: time.Second * 5,
+	}
+	expected := []time.Duration{
+		time.Second,
+		time.Second * 2,
+		time.Second * 4,
+		time.Second * 5,
+		time.Second * 5,
+	}
+	for i, e := range expected {
+		if b := backoff(policy, uint32(i)); b != e {
+			t.Errorf("attempt %d: expected backoff %s, got %s", i, e, b)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	policy := config.RetryPolicy{
+		States: []string{"SYSTEM_ERROR", "canceled"},
+	}
+	if !retryable(policy, tes.State_SYSTEM_ERROR) {
+		t.Error("expected SYSTEM_ERROR to be retried")
+	}
+	if retryable(policy, tes.State_EXECUTOR_ERROR) {
+		t.Error("expected EXECUTOR_ERROR not to be retried")
+	}
+	if retryable(policy, tes.State_CANCELED) {
+		t.Error("expected canceled tasks never to be retried")
+	}
+}
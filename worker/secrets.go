@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// secretPrefix marks a value which references a secret by name,
+// e.g. an executor env value "secret://db-password".
+const secretPrefix = "secret://"
+
+// SecretProvider resolves secrets by name.
+//
+// Resolved values must never be written to events or logs. Errors should
+// describe the secret by name only.
+type SecretProvider interface {
+	Secret(name string) (string, error)
+}
+
+// NewSecretProvider returns the SecretProvider named by conf.Provider.
+// If no provider is configured, nil is returned.
+func NewSecretProvider(conf config.Secrets) (SecretProvider, error) {
+	switch conf.Provider {
+	case "":
+		return nil, nil
+
+	case "file":
+		if conf.File.Dir == "" {
+			return nil, fmt.Errorf("file secret provider: Secrets.File.Dir isn't configured")
+		}
+		return &FileSecretProvider{Dir: conf.File.Dir}, nil
+	}
+	return nil, fmt.Errorf("unknown secret provider: %s", conf.Provider)
+}
+
+// FileSecretProvider reads secrets from a directory, where each file holds
+// one secret and is named by the secret's name. A trailing newline is
+// removed from the value.
+type FileSecretProvider struct {
+	Dir string
+}
+
+// Secret returns the value of the named secret.
+func (f *FileSecretProvider) Secret(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid secret name: %q", name)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(f.Dir, name))
+	if err != nil {
+		return "", fmt.Errorf("can't read secret %q: %s", name, err)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r"), nil
+}
+
+// secretName returns the name of the secret referenced by a value,
+// and false if the value isn't a secret reference.
+func secretName(value string) (string, bool) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(value, secretPrefix), true
+}
+
+// resolveEnv returns a copy of an executor's environment, with the secret
+// references replaced by the secrets' values.
+func resolveEnv(env map[string]string, secrets SecretProvider) (map[string]string, error) {
+	if env == nil {
+		return nil, nil
+	}
+
+	resolved := make(map[string]string, len(env))
+	for k, v := range env {
+		name, ok := secretName(v)
+		if !ok {
+			resolved[k] = v
+			continue
+		}
+		if secrets == nil {
+			return nil, fmt.Errorf("env variable %s references secret %q, but no secret provider is configured", k, name)
+		}
+		val, err := secrets.Secret(name)
+		if err != nil {
+			return nil, fmt.Errorf("env variable %s: %s", k, err)
+		}
+		resolved[k] = val
+	}
+	return resolved, nil
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSecretProvider(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "funnel-test-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	ioutil.WriteFile(filepath.Join(tmp, "db-password"), []byte("hunter2\n"), 0600)
+
+	secrets, err := NewSecretProvider(config.Secrets{
+		Provider: "file",
+		File:     config.FileSecrets{Dir: tmp},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := secrets.Secret("db-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "hunter2" {
+		t.Error("unexpected secret value")
+	}
+
+	for _, name := range []string{"", "missing", "../db-password", ".hidden"} {
+		_, err := secrets.Secret(name)
+		if err == nil {
+			t.Errorf("expected error for secret %q", name)
+		}
+	}
+
+	_, err = NewSecretProvider(config.Secrets{Provider: "unknown"})
+	if err == nil {
+		t.Error("expected error for unknown provider")
+	}
+	p, err := NewSecretProvider(config.Secrets{})
+	if p != nil || err != nil {
+		t.Error("expected no provider by default")
+	}
+}
+
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) Secret(name string) (string, error) {
+	v, ok := f[name]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return v, nil
+}
+
+func TestResolveEnv(t *testing.T) {
+	env := map[string]string{
+		"DB_USER":     "funnel",
+		"DB_PASSWORD": "secret://db-password",
+	}
+
+	res, err := resolveEnv(env, fakeSecrets{"db-password": "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["DB_USER"] != "funnel" || res["DB_PASSWORD"] != "hunter2" {
+		t.Error("unexpected env", res)
+	}
+	// The executor's env isn't modified.
+	if env["DB_PASSWORD"] != "secret://db-password" {
+		t.Error("executor env was modified")
+	}
+
+	_, err = resolveEnv(env, nil)
+	if err == nil {
+		t.Error("expected error without a secret provider")
+	}
+
+	_, err = resolveEnv(env, fakeSecrets{})
+	if err == nil {
+		t.Error("expected error for a missing secret")
+	}
+}
+
+func TestRunArgsEnv(t *testing.T) {
+	c := ContainerConfig{
+		Image: "alpine",
+		Env:   map[string]string{"DB_PASSWORD": "hunter2"},
+	}
+	envFile, err := writeEnvFile(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(envFile)
+
+	args := strings.Join(runArgs(c, envFile), " ")
+	if strings.Contains(args, "hunter2") {
+		t.Error("env value in run args", args)
+	}
+	if !strings.Contains(args, "--env-file "+envFile) {
+		t.Error("missing env file in run args", args)
+	}
+
+	b, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "DB_PASSWORD=hunter2\n" {
+		t.Errorf("unexpected env file content: %q", b)
+	}
+	if fi, _ := os.Stat(envFile); fi.Mode().Perm() != 0600 {
+		t.Error("expected the env file to be private", fi.Mode())
+	}
+
+	c.Env["MULTILINE"] = "a\nb"
+	if _, err := writeEnvFile(c); err == nil {
+		t.Error("expected error for a value with a newline")
+	}
+}
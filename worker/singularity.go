@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SingularityCommand is responsible for configuring and running a
+// Singularity (or Apptainer) container.
+type SingularityCommand struct {
+	ContainerConfig
+	// Binary is the name of the CLI, e.g. "singularity" or "apptainer".
+	Binary string
+	proc   process
+}
+
+// Run runs the singularity command and blocks until done.
+func (scmd *SingularityCommand) Run(ctx context.Context) error {
+	args := []string{"exec", "--containall"}
+
+	if scmd.Workdir != "" {
+		args = append(args, "--pwd", scmd.Workdir)
+	}
+
+	for _, vol := range scmd.Volumes {
+		args = append(args, "--bind", formatVolumeArg(vol))
+	}
+
+	args = append(args, singularityImage(scmd.Image))
+	args = append(args, scmd.Command...)
+
+	scmd.Event.Info("Running command", "cmd", scmd.Binary+" "+strings.Join(args, " "))
+	cmd := exec.Command(scmd.Binary, args...)
+	setStdio(cmd, scmd.ContainerConfig)
+
+	// Singularity passes variables prefixed with SINGULARITYENV_ (or APPTAINERENV_)
+	// into the container, even when the host environment is cleaned.
+	prefix := strings.ToUpper(filepath.Base(scmd.Binary)) + "ENV_"
+	cmd.Env = os.Environ()
+	for k, v := range scmd.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s%s=%s", prefix, k, v))
+	}
+	start := time.Now()
+	err := scmd.proc.run(ctx, cmd)
+	if u := rusageUsage(cmd.ProcessState, time.Since(start)); u != nil {
+		scmd.Event.ResourceUsage(u)
+	}
+	return err
+}
+
+// Stop stops the container process.
+func (scmd *SingularityCommand) Stop() error {
+	scmd.Event.Info("Stopping container", "container", scmd.Name)
+	return scmd.proc.stop()
+}
+
+// Pause stops the container's processes until they are resumed.
+func (scmd *SingularityCommand) Pause() error {
+	return scmd.proc.signal(syscall.SIGSTOP)
+}
+
+// Resume continues the container's stopped processes.
+func (scmd *SingularityCommand) Resume() error {
+	return scmd.proc.signal(syscall.SIGCONT)
+}
+
+// singularityImage converts a docker-style image name into a URI singularity
+// understands. Images which already have a scheme (e.g. "library://")
+// or point to a local image file are left alone.
+func singularityImage(image string) string {
+	if strings.Contains(image, "://") {
+		return image
+	}
+	if _, err := os.Stat(image); err == nil {
+		return image
+	}
+	return "docker://" + image
+}
+
+// process tracks a command running on the host, so that it may be stopped
+// from another goroutine.
+type process struct {
+	mtx sync.Mutex
+	cmd *exec.Cmd
+	// done is closed when the command has exited.
+	done chan struct{}
+}
+
+// run starts the command and blocks until it exits.
+//
+// The command runs in its own process group, so that it can be paused,
+// resumed and stopped along with its child processes.
+//
+// The command isn't started if the context is done, e.g. because the task
+// was canceled while the command was being prepared. The context is checked
+// while p.mtx is held, so a command is either stopped by stop, or never started.
+func (p *process) run(ctx context.Context, cmd *exec.Cmd) error {
+	p.mtx.Lock()
+	p.cmd = cmd
+	p.done = make(chan struct{})
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := ctx.Err()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		close(p.done)
+	}
+	p.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	defer close(p.done)
+	return cmd.Wait()
+}
+
+// running returns true if the command was started and hasn't exited.
+// The caller must hold p.mtx.
+func (p *process) running() bool {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return false
+	}
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// stop sends SIGTERM to the process group of the process, followed by
+// SIGKILL if the process is still running after 10 seconds.
+func (p *process) stop() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if !p.running() {
+		return nil
+	}
+	pid := p.cmd.Process.Pid
+	done := p.done
+	go func() {
+		select {
+		case <-done:
+		case <-time.After(time.Second * 10):
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}
+	}()
+	return syscall.Kill(-pid, syscall.SIGTERM)
+}
+
+// signal sends a signal to the process group of the process,
+// e.g. SIGSTOP and SIGCONT to pause and resume it.
+func (p *process) signal(sig syscall.Signal) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if !p.running() {
+		return fmt.Errorf("process isn't running")
+	}
+	return syscall.Kill(-p.cmd.Process.Pid, sig)
+}
@@ -9,15 +9,30 @@ import (
 )
 
 type stepWorker struct {
-	Conf    config.Worker
-	Command *DockerCommand
-	Event   *events.ExecutorWriter
-	IP      string
+	Conf      config.Worker
+	Container ContainerConfig
+	Runtime   ContainerRuntime
+	Event     *events.ExecutorWriter
+	IP        string
+	// Timeout is the executor's wall-clock timeout. 0 means no timeout.
+	Timeout time.Duration
+	// Control, if set, tracks the running command so that it can be paused.
+	Control *taskControl
+	// Stop, if set, stops a background executor when it's closed.
+	// The executor's exit code is still recorded, and Run returns nil.
+	Stop <-chan struct{}
 }
 
-func (s *stepWorker) Run(ctx context.Context) error {
+func (s *stepWorker) Run(pctx context.Context) error {
 	s.Event.StartTime(time.Now())
 
+	ctx := pctx
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(pctx, s.Timeout)
+		defer cancel()
+	}
+
 	// subctx helps ensure that these goroutines are cleaned up,
 	// even when the task is canceled.
 	subctx, cleanup := context.WithCancel(ctx)
@@ -25,28 +40,50 @@ func (s *stepWorker) Run(ctx context.Context) error {
 
 	// Tail the stdout/err log streams.
 	stdout, stderr := s.Event.TailLogs(subctx, s.Conf.BufferSize, s.Conf.UpdateRate)
-	if s.Command.Stdout != nil {
-		stdout = io.MultiWriter(s.Command.Stdout, stdout)
+	if s.Container.Stdout != nil {
+		stdout = io.MultiWriter(s.Container.Stdout, stdout)
 	}
-	if s.Command.Stderr != nil {
-		stderr = io.MultiWriter(s.Command.Stderr, stderr)
+	if s.Container.Stderr != nil {
+		stderr = io.MultiWriter(s.Container.Stderr, stderr)
 	}
-	s.Command.Stdout = stdout
-	s.Command.Stderr = stderr
+	s.Container.Stdout = stdout
+	s.Container.Stderr = stderr
+
+	cmd := s.Runtime(s.Container)
+	s.Control.setCommand(cmd)
+	defer s.Control.setCommand(nil)
 
 	done := make(chan error, 1)
 	go func() {
-		done <- s.Command.Run()
+		done <- cmd.Run(subctx)
 	}()
 
 	for {
 		select {
 		case <-ctx.Done():
-			// Likely the task was canceled.
-			s.Command.Stop()
+			// Likely the task was canceled, or the executor timed out.
+			// A paused command is resumed first, so that it can stop gracefully.
+			s.Control.resume()
+			cmd.Stop()
 			s.Event.EndTime(time.Now())
+			if pctx.Err() == nil {
+				err := &timeoutError{s.Timeout}
+				s.Event.Error("Executor timed out", "timeout", s.Timeout.String())
+				return err
+			}
 			return ctx.Err()
 
+		case <-s.Stop:
+			cmd.Stop()
+			select {
+			case result := <-done:
+				s.Event.EndTime(time.Now())
+				s.Event.ExitCode(getExitCode(result))
+			case <-ctx.Done():
+				s.Event.EndTime(time.Now())
+			}
+			return nil
+
 		case result := <-done:
 			s.Event.EndTime(time.Now())
 			s.Event.ExitCode(getExitCode(result))
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"strconv"
+	"strings"
+)
+
+// Env vars which expose the task's context to each executor.
+// The resource variables are only set if the task requests the resource.
+// An executor's own env takes precedence over these.
+const (
+	taskIDEnv        = "FUNNEL_TASK_ID"
+	executorIndexEnv = "FUNNEL_EXECUTOR_INDEX"
+	attemptEnv       = "FUNNEL_ATTEMPT"
+	cpusEnv          = "FUNNEL_CPUS"
+	ramGbEnv         = "FUNNEL_RAM_GB"
+	diskGbEnv        = "FUNNEL_DISK_GB"
+)
+
+// withTaskEnv returns a copy of the executor's env,
+// with the task context env vars added.
+func withTaskEnv(env map[string]string, task *tes.Task, attempt uint32, index int) map[string]string {
+	out := map[string]string{
+		taskIDEnv:        task.Id,
+		executorIndexEnv: strconv.Itoa(index),
+		attemptEnv:       fmt.Sprint(attempt),
+	}
+
+	res := task.GetResources()
+	if res.GetCpuCores() > 0 {
+		out[cpusEnv] = fmt.Sprint(res.GetCpuCores())
+	}
+	if res.GetRamGb() > 0 {
+		out[ramGbEnv] = strconv.FormatFloat(res.GetRamGb(), 'f', -1, 64)
+	}
+	if res.GetDiskGb() > 0 {
+		out[diskGbEnv] = strconv.FormatFloat(res.GetDiskGb(), 'f', -1, 64)
+	}
+
+	for k, v := range env {
+		out[k] = v
+	}
+	return out
+}
+
+// expandOutputURLs expands the placeholders in the URLs of the given
+// mapped outputs, so that e.g. "s3://bucket/{task_id}/out.txt" is uploaded
+// to a location per task. Available placeholders:
+//
+// - {task_id}: the task ID.
+// - {task_name}: the task name.
+// - {attempt}: the task attempt, starting at 0.
+func expandOutputURLs(outputs []*tes.Output, task *tes.Task, attempt uint32) {
+	r := strings.NewReplacer(
+		"{task_id}", task.Id,
+		"{task_name}", task.Name,
+		"{attempt}", fmt.Sprint(attempt),
+	)
+	for _, o := range outputs {
+		o.Url = r.Replace(o.Url)
+	}
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"testing"
+)
+
+func TestWithTaskEnv(t *testing.T) {
+	task := &tes.Task{
+		Id: "task-1",
+		Resources: &tes.Resources{
+			CpuCores: 4,
+			RamGb:    1.5,
+		},
+	}
+	env := withTaskEnv(map[string]string{
+		"FOO":            "bar",
+		"FUNNEL_ATTEMPT": "override",
+	}, task, 2, 1)
+
+	expected := map[string]string{
+		"FOO":                   "bar",
+		"FUNNEL_TASK_ID":        "task-1",
+		"FUNNEL_EXECUTOR_INDEX": "1",
+		"FUNNEL_ATTEMPT":        "override",
+		"FUNNEL_CPUS":           "4",
+		"FUNNEL_RAM_GB":         "1.5",
+	}
+	if len(env) != len(expected) {
+		t.Fatal("unexpected env", env)
+	}
+	for k, v := range expected {
+		if env[k] != v {
+			t.Errorf("expected %s=%s, got %q", k, v, env[k])
+		}
+	}
+}
+
+func TestExpandOutputURLs(t *testing.T) {
+	task := &tes.Task{Id: "task-1", Name: "align"}
+	outputs := []*tes.Output{
+		{Url: "s3://bucket/{task_id}/{attempt}/out.txt"},
+		{Url: "file:///results/{task_name}-{task_id}.bam"},
+		{Url: "s3://bucket/{unknown}"},
+	}
+	expandOutputURLs(outputs, task, 1)
+
+	expected := []string{
+		"s3://bucket/task-1/1/out.txt",
+		"file:///results/align-task-1.bam",
+		"s3://bucket/{unknown}",
+	}
+	for i, e := range expected {
+		if outputs[i].Url != e {
+			t.Errorf("expected %s, got %s", e, outputs[i].Url)
+		}
+	}
+}
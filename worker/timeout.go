@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"time"
+)
+
+// Task tags which override the worker's default timeouts.
+// Values are durations, e.g. "6h" or "90m".
+const (
+	// taskTimeoutTag limits the wall-clock time of the whole task.
+	taskTimeoutTag = "funnel_timeout"
+	// executorTimeoutTag limits the wall-clock time of each executor.
+	// A timeout for the executor at index i may be set with the tag
+	// "funnel_executor_timeout_<i>".
+	executorTimeoutTag = "funnel_executor_timeout"
+)
+
+// timeouts holds the wall-clock timeouts of a task. 0 means no timeout.
+type timeouts struct {
+	task      time.Duration
+	executors []time.Duration
+}
+
+// getTimeouts gets the task and executor timeouts from the task's tags,
+// falling back to the defaults in the worker config.
+func getTimeouts(task *tes.Task, conf config.Worker) (timeouts, error) {
+	var t timeouts
+	var err error
+
+	tags := task.GetTags()
+	t.task, err = parseTimeoutTag(tags, taskTimeoutTag, conf.TaskTimeout)
+	if err != nil {
+		return t, err
+	}
+
+	def, err := parseTimeoutTag(tags, executorTimeoutTag, conf.ExecutorTimeout)
+	if err != nil {
+		return t, err
+	}
+
+	for i := range task.GetExecutors() {
+		key := fmt.Sprintf("%s_%d", executorTimeoutTag, i)
+		d, err := parseTimeoutTag(tags, key, def)
+		if err != nil {
+			return t, err
+		}
+		t.executors = append(t.executors, d)
+	}
+	return t, nil
+}
+
+// executor returns the timeout of the executor at index i.
+func (t timeouts) executor(i int) time.Duration {
+	if i < len(t.executors) {
+		return t.executors[i]
+	}
+	return 0
+}
+
+func parseTimeoutTag(tags map[string]string, key string, def time.Duration) (time.Duration, error) {
+	v, ok := tags[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s tag %q: expected a duration, e.g. 6h or 90m", key, v)
+	}
+	return d, nil
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/proto/tes"
+	"testing"
+	"time"
+)
+
+func TestGetTimeouts(t *testing.T) {
+	conf := config.Worker{
+		TaskTimeout:     time.Hour,
+		ExecutorTimeout: time.Minute,
+	}
+	task := &tes.Task{
+		Executors: []*tes.Executor{{}, {}, {}},
+		Tags: map[string]string{
+			"funnel_executor_timeout":   "10m",
+			"funnel_executor_timeout_2": "30s",
+		},
+	}
+
+	limits, err := getTimeouts(task, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.task != time.Hour {
+		t.Error("expected the default task timeout", limits.task)
+	}
+	expected := []time.Duration{time.Minute * 10, time.Minute * 10, time.Second * 30}
+	for i, e := range expected {
+		if limits.executor(i) != e {
+			t.Errorf("executor %d: expected timeout %s, got %s", i, e, limits.executor(i))
+		}
+	}
+
+	task.Tags["funnel_timeout"] = "forever"
+	_, err = getTimeouts(task, conf)
+	if err == nil {
+		t.Error("expected error for invalid timeout tag")
+	}
+}
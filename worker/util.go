@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 // getExitCode gets the exit status (i.e. exit code) from the result of an executed command.
 // The exit code is zero if the command completed without error.
 func getExitCode(err error) int {
 	if err != nil {
+		if exiterr, ok := err.(*exitError); ok {
+			return exiterr.code
+		}
 		if exiterr, exitOk := err.(*exec.ExitError); exitOk {
 			if status, statusOk := exiterr.Sys().(syscall.WaitStatus); statusOk {
 				return status.ExitStatus()
@@ -23,6 +27,50 @@ func getExitCode(err error) int {
 	return 0
 }
 
+// exitError is returned by container commands which get the exit code
+// of the container from an API, rather than from an *exec.ExitError.
+type exitError struct {
+	code int
+	// oomKilled is true if the container was killed because it exceeded
+	// its memory limit.
+	oomKilled bool
+}
+
+func (e *exitError) Error() string {
+	if e.oomKilled {
+		return fmt.Sprintf("exit status %d: killed after exceeding the memory limit", e.code)
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// systemError wraps an error from a container command which was caused by
+// the system (e.g. the image could not be pulled) rather than by the
+// executor's command.
+type systemError struct {
+	error
+}
+
+// isSystemError returns true if the error is a systemError.
+func isSystemError(err error) bool {
+	_, ok := err.(*systemError)
+	return ok
+}
+
+// timeoutError is returned by an executor which ran longer than its timeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("executor timed out after %s", e.timeout)
+}
+
+// isTimeoutError returns true if the error is a timeoutError.
+func isTimeoutError(err error) bool {
+	_, ok := err.(*timeoutError)
+	return ok
+}
+
 // recover from panic and call "cb" with an error value.
 func handlePanic(cb func(error)) {
 	if r := recover(); r != nil {
@@ -39,6 +87,7 @@ type helper struct {
 	syserr       error
 	execerr      error
 	taskCanceled bool
+	taskRequeued bool
 	ctx          context.Context
 }
 
@@ -10,7 +10,9 @@ import (
 	"github.com/ohsu-comp-bio/funnel/storage"
 	"github.com/ohsu-comp-bio/funnel/util"
 	"os"
+	"path"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -23,6 +25,8 @@ type DefaultWorker struct {
 	Store      storage.Storage
 	TaskReader TaskReader
 	Event      *events.TaskWriter
+	// InputCache is an optional node-level cache of input files.
+	InputCache *InputCache
 }
 
 // Close cleans up worker resources, e.g. closing the event writers.
@@ -38,45 +42,90 @@ func (r *DefaultWorker) Run(pctx context.Context) {
 	//
 	// The steps are:
 	// - prepare the working directory
+	// - log the host details (hostname, IP address, node ID, version)
 	// - map the task files to the working directory
-	// - log the IP address
 	// - set up the storage configuration
 	// - validate input and output files
 	// - download inputs
-	// - run the steps (docker)
+	// - run the steps (docker, singularity, etc.)
 	// - upload the outputs
 
 	var run helper
 	var task *tes.Task
 
-	r.Event.Info("Version", version.LogFields()...)
-
 	task, run.syserr = r.TaskReader.Task()
 
+	// Events are logged to the task's current attempt.
+	// A retried task has a task log per attempt.
+	r.Event = r.Event.WithAttempt(task.Attempt())
+
+	r.Event.Info("Version", version.LogFields()...)
+	r.Event.Metadata(hostMetadata(r.Conf))
+
 	r.Event.State(tes.State_INITIALIZING)
 	r.Event.StartTime(time.Now())
 
+	// The working directory may be left over from a previous attempt.
+	// Make sure the node's janitor doesn't delete it while the task runs.
+	os.Remove(filepath.Join(r.Mapper.dir, doneFile))
+
+	// Send heartbeats until the final state is set, including while
+	// the task waits to be retried, so the server doesn't consider it lost.
+	if r.Conf.HeartbeatRate > 0 {
+		hctx, stopHeartbeats := context.WithCancel(pctx)
+		defer stopHeartbeats()
+		go r.sendHeartbeats(hctx)
+	}
+
+	// Get the task and executor timeouts from the tags or config.
+	var limits timeouts
+	if run.ok() {
+		limits, run.syserr = getTimeouts(task, r.Conf)
+	}
+
 	// Run the final logging/state steps in a deferred function
 	// to ensure they always run, even if there's a missed error.
 	defer func() {
 		r.Event.EndTime(time.Now())
 
+		var state tes.State
 		switch {
+		case run.taskRequeued:
+			// The server requeued the task, e.g. because it didn't get
+			// the worker's heartbeats. Another worker runs the next attempt,
+			// so this worker must not set the task's state.
+			r.Event.Error("Task was requeued by the server, stopping")
+			r.cleanupWorkDir(tes.State_SYSTEM_ERROR)
+			return
 		case run.taskCanceled:
 			// The task was canceled.
 			r.Event.Info("Canceled")
-			r.Event.State(tes.State_CANCELED)
+			state = tes.State_CANCELED
+		case limits.task > 0 && run.ctx != nil && run.ctx.Err() == context.DeadlineExceeded:
+			// The task ran longer than its timeout. The timeout may be hit
+			// while transferring files, not just in an executor, so this
+			// isn't an executor error.
+			r.Event.Error("Task timed out", "timeout", limits.task.String())
+			state = tes.State_SYSTEM_ERROR
 		case run.execerr != nil:
 			// One of the executors failed
 			r.Event.Error("Exec error", "error", run.execerr)
-			r.Event.State(tes.State_EXECUTOR_ERROR)
+			state = tes.State_EXECUTOR_ERROR
 		case run.syserr != nil:
 			// Something else failed
 			// TODO should we do something special for run.err == context.Canceled?
 			r.Event.Error("System error", "error", run.syserr)
-			r.Event.State(tes.State_SYSTEM_ERROR)
+			state = tes.State_SYSTEM_ERROR
 		default:
-			r.Event.State(tes.State_COMPLETE)
+			state = tes.State_COMPLETE
+		}
+
+		// Clean up the working directory before the task is requeued
+		// or the final state is set, which may start another attempt.
+		r.cleanupWorkDir(state)
+
+		if !r.retry(pctx, state) {
+			r.Event.State(state)
 		}
 	}()
 
@@ -85,11 +134,28 @@ func (r *DefaultWorker) Run(pctx context.Context) {
 		run.syserr = e
 	})
 
-	ctx := r.pollForCancel(pctx, func() {
-		run.taskCanceled = true
+	// Watch for the task being canceled, paused, resumed or requeued.
+	// The watcher stops before the final state is set.
+	wctx, stopWatching := context.WithCancel(pctx)
+	defer stopWatching()
+	control := newTaskControl()
+	ctx := r.watchState(wctx, control, func(state tes.State) {
+		if state == tes.State_QUEUED {
+			run.taskRequeued = true
+		} else {
+			run.taskCanceled = true
+		}
 	})
 	run.ctx = ctx
 
+	// Stop the task if it runs longer than its timeout.
+	if limits.task > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.task)
+		defer cancel()
+		run.ctx = ctx
+	}
+
 	// Create working dir
 	var dir string
 	if run.ok() {
@@ -104,10 +170,69 @@ func (r *DefaultWorker) Run(pctx context.Context) {
 		run.syserr = r.Mapper.MapTask(task)
 	}
 
+	// Expand placeholders in the output URLs, e.g. {task_id}.
+	if run.ok() {
+		expandOutputURLs(r.Mapper.Outputs, task, r.Event.Attempt())
+	}
+
 	// Configure a task-specific storage backend.
 	// This provides download/upload for inputs/outputs.
 	if run.ok() {
 		r.Store, run.syserr = r.Store.WithConfig(r.Conf.Storage)
+		r.Store = r.Store.WithParallelTransfers(r.Conf.MaxParallelTransfers)
+		r.Store = r.Store.WithRetryNotify(r.storageRetried)
+	}
+
+	// Pick the container runtime (docker, singularity, etc.) for the executors.
+	var runtime ContainerRuntime
+	if run.ok() {
+		runtime, run.syserr = NewContainerRuntime(r.Conf)
+	}
+
+	// Resolve the secrets referenced by the executors' environment.
+	// The resolved values are only passed to the container runtime.
+	var secrets SecretProvider
+	if run.ok() {
+		secrets, run.syserr = NewSecretProvider(r.Conf.Secrets)
+	}
+	envs := make([]map[string]string, len(task.Executors))
+	for i, d := range task.Executors {
+		if run.ok() {
+			envs[i], run.syserr = resolveEnv(d.Env, secrets)
+		}
+		// Expose the task's context, e.g. FUNNEL_TASK_ID, to the executor.
+		envs[i] = withTaskEnv(envs[i], task, r.Event.Attempt(), i)
+	}
+
+	// Get the executors which continue on error, or run in the background.
+	var modes []executorMode
+	if run.ok() {
+		modes, run.syserr = getExecutorModes(task)
+	}
+
+	// Get the options for uploading outputs, e.g. optional outputs.
+	var outputPolicy outputPolicy
+	if run.ok() {
+		outputPolicy, run.syserr = getOutputPolicy(task, r.Conf)
+	}
+
+	// Get the expected checksums of the inputs, and the checksum algorithm
+	// of the outputs.
+	var checksums checksumPolicy
+	if run.ok() {
+		checksums, run.syserr = getChecksumPolicy(task, r.Conf)
+	}
+
+	// Get the network and user options of the containers.
+	var iso isolation
+	if run.ok() {
+		iso, run.syserr = getIsolation(task, r.Conf)
+	}
+
+	// Load the credentials for pulling images from private registries.
+	var creds RegistryCredentials
+	if run.ok() {
+		creds, run.syserr = getRegistryCredentials(task, r.Conf.RegistryAuth, secrets)
 	}
 
 	if run.ok() {
@@ -119,17 +244,18 @@ func (r *DefaultWorker) Run(pctx context.Context) {
 	}
 
 	// Download inputs
-	for _, input := range r.Mapper.Inputs {
-		if run.ok() {
-			r.Event.Info("Starting download", "url", input.Url)
-			err := r.Store.Get(ctx, input.Url, input.Path, input.Type)
-			if err != nil {
-				run.syserr = err
-				r.Event.Error("Download failed", "url", input.Url, "error", err)
-			} else {
-				r.Event.Info("Download finished", "url", input.Url)
+	if run.ok() {
+		done := r.stage("download")
+		var cached []*CachedInput
+		cached, run.syserr = r.downloadInputs(ctx, checksums.inputs)
+		done()
+		// Cached inputs which are mounted into the container
+		// can't be evicted from the cache until the task is done.
+		defer func() {
+			for _, c := range cached {
+				c.Release()
 			}
-		}
+		}()
 	}
 
 	if run.ok() {
@@ -137,57 +263,307 @@ func (r *DefaultWorker) Run(pctx context.Context) {
 	}
 
 	// Run steps
+	var executed func()
+	if run.ok() {
+		executed = r.stage("execute")
+	}
+	// Background executors run until the other executors are done.
+	var background sync.WaitGroup
+	stopBackground := make(chan struct{})
+
 	for i, d := range task.Executors {
 		s := &stepWorker{
-			Conf:  r.Conf,
-			Event: r.Event.NewExecutorWriter(uint32(i)),
-			Command: &DockerCommand{
-				Image:         d.Image,
-				Command:       d.Command,
-				Env:           d.Env,
-				Volumes:       r.Mapper.Volumes,
-				Workdir:       d.Workdir,
-				ContainerName: fmt.Sprintf("%s-%d", task.Id, i),
+			Conf:    r.Conf,
+			Event:   r.Event.NewExecutorWriter(uint32(i)),
+			Runtime: runtime,
+			Control: control,
+			Timeout: limits.executor(i),
+			Container: ContainerConfig{
+				Image:     d.Image,
+				Command:   d.Command,
+				Env:       envs[i],
+				Volumes:   r.Mapper.Volumes,
+				Workdir:   d.Workdir,
+				Name:      fmt.Sprintf("%s-%d", task.Id, i),
+				Resources: task.Resources,
 				// TODO make RemoveContainer configurable
 				RemoveContainer: true,
 				Event:           r.Event.NewExecutorWriter(uint32(i)),
+				NetworkMode:     iso.network,
+				User:            iso.user,
+				Groups:          iso.groups,
+				PullPolicy:      r.Conf.PullPolicy,
+				RegistryAuth:    creds,
+				ImageDigest:     r.imageDigestRecorder(i),
 			},
 		}
 
+		// Background executors aren't paused, since the task control
+		// only tracks the current executor.
+		if modes != nil && modes[i].background {
+			s.Control = nil
+			s.Stop = stopBackground
+		}
+
+		// A paused task doesn't start the next executor until it is resumed.
+		if run.ok() {
+			run.syserr = control.wait(ctx)
+		}
+
 		// Opens stdin/out/err files and updates those fields on "cmd".
 		if run.ok() {
 			run.syserr = r.openStepLogs(s, d)
 		}
 
+		// Collect the full stdout/err, if archiving is configured.
+		var archive *logArchive
 		if run.ok() {
-			run.execerr = s.Run(ctx)
+			archive, run.syserr = r.openLogArchive(s, task.Id, i)
+		}
+
+		if run.ok() && modes[i].background {
+			background.Add(1)
+			go func(i int, s *stepWorker, archive *logArchive) {
+				defer background.Done()
+				// Failures of background executors don't fail the task.
+				err := s.Run(ctx)
+				if err != nil && ctx.Err() == nil {
+					r.Event.Error("Background executor failed", "index", i, "error", err)
+				}
+				r.uploadLogArchive(pctx, archive)
+			}(i, s, archive)
+			continue
 		}
-	}
 
-	// Upload outputs
-	var outputs []*tes.OutputFileLog
-	for _, output := range r.Mapper.Outputs {
 		if run.ok() {
-			r.Event.Info("Starting upload", "url", output.Url)
-			r.fixLinks(output.Path)
-			out, err := r.Store.Put(ctx, output.Url, output.Path, output.Type)
-			if err != nil {
+			err := s.Run(ctx)
+			// Only the executor's own failures are ignored. Timeouts still
+			// fail the task, since they stop the executor before it's done.
+			if err != nil && modes[i].continueOnError && !isSystemError(err) && !isTimeoutError(err) && ctx.Err() == nil {
+				r.Event.Info("Executor failed, continuing", "index", i, "error", err)
+				err = nil
+			}
+			if isSystemError(err) {
 				run.syserr = err
-				r.Event.Error("Upload failed", "url", output.Url, "error", err)
 			} else {
-				r.Event.Info("Upload finished", "url", output.Url)
+				run.execerr = err
 			}
-			outputs = append(outputs, out...)
+			// Archive the logs even if the task was canceled or timed out.
+			r.uploadLogArchive(pctx, archive)
 		}
 	}
+	// Stop the background executors, and wait for their exit codes.
+	close(stopBackground)
+	background.Wait()
+	if executed != nil {
+		executed()
+	}
+
+	// Upload outputs. The checksums of the output files, if enabled,
+	// are computed while they are uploaded.
+	var outputs []*tes.OutputFileLog
+	var sums map[string]string
+	var uploaded bool
+	if run.ok() {
+		done := r.stage("upload")
+		outputs, sums, run.syserr = r.uploadOutputs(ctx, r.Mapper.Outputs, outputPolicy.optional, true, checksums.output)
+		done()
+		uploaded = run.ok()
+	} else if run.syserr == nil && run.execerr != nil && !outputPolicy.onFailure.empty() {
+		// An executor failed. Upload the outputs selected for failed tasks,
+		// e.g. logs and partial results. These are likely incomplete,
+		// so they're all optional, and upload errors are only logged.
+		// A failed upload doesn't stop the others, and every output which
+		// was uploaded is reported.
+		done := r.stage("upload")
+		var err error
+		outputs, sums, err = r.uploadOutputs(ctx, r.failureOutputs(outputPolicy), outputSet{all: true}, false, checksums.output)
+		done()
+		if err != nil {
+			r.Event.Error("Couldn't upload outputs of failed task", "error", err)
+		}
+		uploaded = true
+	}
+
 	// unmap paths for OutputFileLog
 	for _, o := range outputs {
 		o.Path = r.Mapper.ContainerPath(o.Path)
 	}
 
-	if run.ok() {
-		r.Event.Outputs(outputs)
+	if uploaded {
+		r.Event.Outputs(outputs, sums)
+	}
+}
+
+// downloadInputs downloads the task's inputs, running up to
+// Conf.MaxParallelTransfers downloads at once. The first failed download
+// cancels the others.
+//
+// If the worker has an input cache, files are provided by the cache
+// when possible. Cached inputs are returned so that they can be released
+// when the task is done.
+//
+// Inputs with an expected checksum, by container path, are verified
+// after they are downloaded.
+func (r *DefaultWorker) downloadInputs(ctx context.Context, checksums map[string]checksum) ([]*CachedInput, error) {
+	inputs := r.Mapper.Inputs
+	cached := make([]*CachedInput, len(inputs))
+
+	err := util.ParallelDo(ctx, len(inputs), r.Conf.MaxParallelTransfers, func(ctx context.Context, i int) error {
+		input := inputs[i]
+		r.Event.Info("Starting download", "url", input.Url)
+
+		if r.InputCache != nil && input.Type == tes.FileType_FILE {
+			c, err := r.InputCache.Get(ctx, r.Store, input.Url, input.Path)
+			if err == nil {
+				if c.Hit {
+					r.Event.Info("Input cache hit", "url", input.Url)
+				} else {
+					r.Event.Info("Input cache miss", "url", input.Url)
+				}
+				cached[i] = c
+				p := input.Path
+				if c.Mount != "" {
+					p = c.Mount
+				}
+				return r.finishDownload(ctx, input, p, checksums)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Fall back to downloading the file directly.
+			r.Event.Debug("Input can't be cached", "url", input.Url, "error", err)
+		}
+
+		err := r.Store.Get(ctx, input.Url, input.Path, input.Type)
+		if err != nil {
+			r.Event.Error("Download failed", "url", input.Url, "error", err)
+			return err
+		}
+		return r.finishDownload(ctx, input, input.Path, checksums)
+	})
+
+	var out []*CachedInput
+	for i, c := range cached {
+		if c == nil {
+			continue
+		}
+		// The cached file couldn't be hard-linked into the working directory,
+		// so mount it into the container from the cache instead.
+		if c.Mount != "" {
+			for j, v := range r.Mapper.Volumes {
+				if v.HostPath == inputs[i].Path {
+					r.Mapper.Volumes[j].HostPath = c.Mount
+				}
+			}
+		}
+		out = append(out, c)
 	}
+	return out, err
+}
+
+// finishDownload verifies the checksum of the downloaded input at the host
+// path "p", if the input has an expected checksum.
+func (r *DefaultWorker) finishDownload(ctx context.Context, input *tes.Input, p string, checksums map[string]checksum) error {
+	if c, ok := checksums[path.Clean(r.Mapper.ContainerPath(input.Path))]; ok {
+		err := r.verifyInput(ctx, input.Url, p, c)
+		if err != nil {
+			r.Event.Error("Download failed", "url", input.Url, "error", err)
+			return err
+		}
+		r.Event.Info("Verified input checksum", "url", input.Url, "algorithm", c.algorithm)
+	}
+	r.Event.Info("Download finished", "url", input.Url)
+	return nil
+}
+
+// uploadOutputs uploads the given mapped outputs, running up to
+// Conf.MaxParallelTransfers uploads at once. The limit is shared with the
+// files of directory outputs, see storage.Storage.WithParallelTransfers.
+//
+// If failFast is true, the first failed upload cancels the others.
+// Otherwise, every upload runs, the errors are returned together,
+// and the returned logs include every output which was uploaded.
+//
+// If "algorithm" isn't empty, the checksums of the uploaded files are
+// computed while they are uploaded, and returned by URL.
+//
+// Outputs with glob patterns are expanded first, uploading each match.
+// Optional outputs which don't exist, or patterns which don't match,
+// are skipped.
+func (r *DefaultWorker) uploadOutputs(ctx context.Context, mapped []*tes.Output, optional outputSet, failFast bool, algorithm string) ([]*tes.OutputFileLog, map[string]string, error) {
+	var outputs []*tes.Output
+	for _, output := range mapped {
+		opt := optional.contains(r.Mapper.ContainerPath(output.Path))
+
+		if !isGlob(output.Path) {
+			if _, err := os.Lstat(output.Path); opt && os.IsNotExist(err) {
+				r.Event.Info("Skipping missing optional output", "url", output.Url)
+				continue
+			}
+			outputs = append(outputs, output)
+			continue
+		}
+		matches, err := expandGlob(output)
+		if err != nil && opt {
+			r.Event.Info("Skipping optional output pattern", "url", output.Url, "error", err)
+			continue
+		}
+		if err != nil {
+			r.Event.Error("Couldn't match output pattern", "url", output.Url, "error", err)
+			return nil, nil, err
+		}
+		outputs = append(outputs, matches...)
+	}
+	logs := make([][]*tes.OutputFileLog, len(outputs))
+	sums := make([]map[string]string, len(outputs))
+	errs := make([]error, len(outputs))
+
+	err := util.ParallelDo(ctx, len(outputs), r.Conf.MaxParallelTransfers, func(ctx context.Context, i int) error {
+		output := outputs[i]
+		r.Event.Info("Starting upload", "url", output.Url)
+		r.fixLinks(output.Path)
+		out, s, err := r.Store.PutChecksum(ctx, output.Url, output.Path, output.Type, algorithm)
+		if err != nil {
+			r.Event.Error("Upload failed", "url", output.Url, "error", err)
+			if failFast {
+				return err
+			}
+			errs[i] = err
+			return nil
+		}
+		r.Event.Info("Upload finished", "url", output.Url)
+		logs[i] = out
+		sums[i] = s
+		return nil
+	})
+
+	var all []*tes.OutputFileLog
+	for _, l := range logs {
+		all = append(all, l...)
+	}
+	var checksums map[string]string
+	if algorithm != "" {
+		checksums = map[string]string{}
+		for _, s := range sums {
+			for u, sum := range s {
+				checksums[u] = sum
+			}
+		}
+	}
+
+	if err == nil && !failFast {
+		var merr util.MultiError
+		for _, e := range errs {
+			if e != nil {
+				merr = append(merr, e)
+			}
+		}
+		if merr != nil {
+			err = merr
+		}
+	}
+	return all, checksums, err
 }
 
 // fixLinks walks the output paths, fixing cases where a symlink is
@@ -242,7 +618,7 @@ func (r *DefaultWorker) openStepLogs(s *stepWorker, d *tes.Executor) error {
 	// Find the path for task stdin
 	var err error
 	if d.Stdin != "" {
-		s.Command.Stdin, err = r.Mapper.OpenHostFile(d.Stdin)
+		s.Container.Stdin, err = r.Mapper.OpenHostFile(d.Stdin)
 		if err != nil {
 			s.Event.Error("Couldn't prepare log files", err)
 			return err
@@ -251,7 +627,7 @@ func (r *DefaultWorker) openStepLogs(s *stepWorker, d *tes.Executor) error {
 
 	// Create file for task stdout
 	if d.Stdout != "" {
-		s.Command.Stdout, err = r.Mapper.CreateHostFile(d.Stdout)
+		s.Container.Stdout, err = r.Mapper.CreateHostFile(d.Stdout)
 		if err != nil {
 			s.Event.Error("Couldn't prepare log files", err)
 			return err
@@ -260,7 +636,7 @@ func (r *DefaultWorker) openStepLogs(s *stepWorker, d *tes.Executor) error {
 
 	// Create file for task stderr
 	if d.Stderr != "" {
-		s.Command.Stderr, err = r.Mapper.CreateHostFile(d.Stderr)
+		s.Container.Stderr, err = r.Mapper.CreateHostFile(d.Stderr)
 		if err != nil {
 			s.Event.Error("Couldn't prepare log files", err)
 			return err
@@ -270,6 +646,27 @@ func (r *DefaultWorker) openStepLogs(s *stepWorker, d *tes.Executor) error {
 	return nil
 }
 
+// imageDigestRecorder returns a function which records the digest of the
+// image used by the executor at the given index in the task metadata.
+func (r *DefaultWorker) imageDigestRecorder(index int) func(string) {
+	return func(digest string) {
+		r.Event.Metadata(map[string]string{imageDigestMetadata(index): digest})
+	}
+}
+
+// storageRetried logs a retry of a failed storage request
+// to the task's system logs.
+func (r *DefaultWorker) storageRetried(retry storage.Retry) {
+	r.Event.Info("Retrying storage request",
+		"op", retry.Op,
+		"url", retry.URL,
+		"attempt", retry.Attempt,
+		"maxAttempts", r.Conf.Storage.Retry.MaxAttempts,
+		"backoff", retry.Backoff.String(),
+		"error", retry.Err,
+	)
+}
+
 // Validate the input downloads
 func (r *DefaultWorker) validateInputs() error {
 	for _, input := range r.Mapper.Inputs {
@@ -283,34 +680,9 @@ func (r *DefaultWorker) validateInputs() error {
 // Validate the output uploads
 func (r *DefaultWorker) validateOutputs() error {
 	for _, output := range r.Mapper.Outputs {
-		if !r.Store.Supports(output.Url, output.Path, output.Type) {
+		if !r.Store.SupportsPut(output.Url, output.Path, output.Type) {
 			return fmt.Errorf("Output upload not supported by storage: %v", output)
 		}
 	}
 	return nil
 }
-
-func (r *DefaultWorker) pollForCancel(ctx context.Context, f func()) context.Context {
-	taskctx, cancel := context.WithCancel(ctx)
-
-	// Start a goroutine that polls the server to watch for a canceled state.
-	// If a cancel state is found, "taskctx" is canceled.
-	go func() {
-		ticker := time.NewTicker(r.Conf.UpdateRate)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-taskctx.Done():
-				return
-			case <-ticker.C:
-				state, _ := r.TaskReader.State()
-				if tes.TerminalState(state) {
-					cancel()
-					f()
-				}
-			}
-		}
-	}()
-	return taskctx
-}